@@ -0,0 +1,586 @@
+package anystatic
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IsArchive reports whether name has an archive suffix served by OpenArchive.
+func IsArchive(name string) bool {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenRoot opens root as an archive (see IsArchive) or as a directory with the symlink policy.
+func OpenRoot(root string, policy SymlinkPolicy) (fs.StatFS, error) {
+	if IsArchive(root) {
+		if st, err := os.Stat(root); err == nil && !st.IsDir() {
+			return OpenArchive(root)
+		}
+	}
+	d, err := NewDirFS(root, policy)
+	if err != nil {
+		return nil, err
+	}
+	WarnSymlinks(root, policy)
+	return d, nil
+}
+
+// OpenRoots opens each root with OpenRoot; several roots are layered with NewUnionFS, the first on top.
+func OpenRoots(roots []string, policy SymlinkPolicy) (fs.StatFS, error) {
+	if len(roots) == 1 {
+		return OpenRoot(roots[0], policy)
+	}
+	layers := make([]fs.StatFS, 0, len(roots))
+	for _, root := range roots {
+		layer, err := OpenRoot(root, policy)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+	return NewUnionFS(layers...), nil
+}
+
+// OpenArchive opens a .zip, .tar or .tar.gz (.tgz) file as a read-only filesystem.
+// Members of .zip and .tar are read in place, .tar.gz members are loaded into memory.
+// A zip member stored with deflate is also offered as a deflate variant, sent as
+// Content-Encoding: deflate without recompressing. The variant is not listed in its
+// directory and is named with the suffix a Handler serves deflate with (".deflate"
+// until NewHandler sets it, none when deflate is not among its encodings).
+func OpenArchive(name string) (fs.StatFS, error) {
+	lower := strings.ToLower(name)
+	fp, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	var afs *archiveFS
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		var st fs.FileInfo
+		if st, err = fp.Stat(); err == nil {
+			afs, err = loadZip(fp, st.Size())
+		}
+	case strings.HasSuffix(lower, ".tar"):
+		afs, err = loadTar(&posReader{r: fp}, fp)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		afs, err = loadTarGz(fp)
+		fp.Close()
+	default:
+		err = fmt.Errorf("unknown archive type: %s", name)
+	}
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	afs.name = name
+	slog.Info("archive loaded", "archive", name, "entries", len(afs.files))
+	return afs, nil
+}
+
+// archiveFile is a member (or a synthesized directory) of an archive.
+// It is both the fs.FileInfo and the fs.DirEntry of the member.
+type archiveFile struct {
+	name    string // full path
+	mode    fs.FileMode
+	modTime time.Time
+	size    int64
+
+	ra     io.ReaderAt // seekable content at offset
+	offset int64
+	zf     *zip.File // content streamed from a compressed zip member
+	zlib   *zlibMember
+}
+
+func (f *archiveFile) Name() string               { return pathpkg.Base(f.name) }
+func (f *archiveFile) Size() int64                { return f.size }
+func (f *archiveFile) Mode() fs.FileMode          { return f.mode }
+func (f *archiveFile) ModTime() time.Time         { return f.modTime }
+func (f *archiveFile) IsDir() bool                { return f.mode.IsDir() }
+func (f *archiveFile) Sys() any                   { return nil }
+func (f *archiveFile) Type() fs.FileMode          { return f.mode.Type() }
+func (f *archiveFile) Info() (fs.FileInfo, error) { return f, nil }
+
+type archiveFS struct {
+	name     string
+	files    map[string]*archiveFile
+	children map[string][]*archiveFile
+
+	deflate    map[string]*archiveFile // virtual deflate variants by member name
+	deflateExt string                  // suffix of the deflate variants, "" for none
+}
+
+// deflateVariantFS is a filesystem offering deflate variants of its own (see OpenArchive).
+// NewHandler sets their suffix to the one it serves deflate with.
+type deflateVariantFS interface {
+	setDeflateExt(ext string)
+}
+
+func newArchiveFS() *archiveFS {
+	root := &archiveFile{name: ".", mode: fs.ModeDir | 0o555}
+	return &archiveFS{files: map[string]*archiveFile{".": root}, children: map[string][]*archiveFile{}, deflate: map[string]*archiveFile{}, deflateExt: ".deflate"}
+}
+
+func (a *archiveFS) setDeflateExt(ext string) {
+	a.deflateExt = ext
+}
+
+func (a *archiveFS) String() string {
+	return a.name
+}
+
+// cleanMemberName turns an archive member name into an fs path, ok is false for unsafe names.
+func cleanMemberName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", false
+		}
+	}
+	name = strings.TrimPrefix(pathpkg.Clean("/"+name), "/")
+	if name == "" || !fs.ValidPath(name) {
+		return "", false
+	}
+	return name, true
+}
+
+func (a *archiveFS) add(f *archiveFile) {
+	if _, ok := a.files[f.name]; ok {
+		if !f.IsDir() {
+			slog.Warn("duplicate archive member, later one wins", "name", f.name)
+			a.files[f.name] = f
+			a.replaceChild(f)
+		}
+		return
+	}
+	a.files[f.name] = f
+	dir := pathpkg.Dir(f.name)
+	if _, ok := a.files[dir]; !ok {
+		a.add(&archiveFile{name: dir, mode: fs.ModeDir | 0o555, modTime: f.modTime})
+	}
+	a.children[dir] = append(a.children[dir], f)
+}
+
+func (a *archiveFS) replaceChild(f *archiveFile) {
+	siblings := a.children[pathpkg.Dir(f.name)]
+	for i, c := range siblings {
+		if c.name == f.name {
+			siblings[i] = f
+		}
+	}
+}
+
+// finish sorts directories and prepares the virtual deflate variants.
+func (a *archiveFS) finish() {
+	for _, f := range a.files {
+		if f.zf == nil || f.zf.Method != zip.Deflate {
+			continue
+		}
+		zm := &zlibMember{zf: f.zf}
+		a.deflate[f.name] = &archiveFile{name: f.name, mode: f.mode, modTime: f.modTime, size: zm.size(), zlib: zm}
+	}
+	for _, list := range a.children {
+		sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	}
+}
+
+func (a *archiveFS) lookup(op, name string) (*archiveFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if f, ok := a.files[name]; ok {
+		return f, nil
+	}
+	// a real member of the same name wins over a variant
+	if a.deflateExt != "" && strings.HasSuffix(name, a.deflateExt) {
+		if v, ok := a.deflate[strings.TrimSuffix(name, a.deflateExt)]; ok {
+			return &archiveFile{name: name, mode: v.mode, modTime: v.modTime, size: v.size, zlib: v.zlib}, nil
+		}
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+func (a *archiveFS) Stat(name string) (fs.FileInfo, error) {
+	f, err := a.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (a *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := a.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !f.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	list := a.children[name]
+	res := make([]fs.DirEntry, len(list))
+	for i, c := range list {
+		res[i] = c
+	}
+	return res, nil
+}
+
+func (a *archiveFS) Open(name string) (fs.File, error) {
+	f, err := a.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case f.IsDir():
+		return &archiveDir{f: f, fsys: a}, nil
+	case f.ra != nil:
+		return &archiveReader{SectionReader: io.NewSectionReader(f.ra, f.offset, f.size), f: f}, nil
+	case f.zlib != nil:
+		ra, err := f.zlib.readerAt()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &archiveReader{SectionReader: io.NewSectionReader(ra, 0, f.size), f: f}, nil
+	case f.zf != nil:
+		rc, err := f.zf.Open()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &archiveStream{ReadCloser: rc, f: f}, nil
+	}
+	return &archiveReader{SectionReader: io.NewSectionReader(bytes.NewReader(nil), 0, 0), f: f}, nil
+}
+
+// archiveReader is a seekable member.
+type archiveReader struct {
+	*io.SectionReader
+	f *archiveFile
+}
+
+func (r *archiveReader) Stat() (fs.FileInfo, error) { return r.f, nil }
+func (r *archiveReader) Close() error               { return nil }
+
+// archiveStream is a compressed zip member, decompressed while reading.
+// Seeking decompresses up to the offset, from the start again when seeking back.
+type archiveStream struct {
+	io.ReadCloser
+	f   *archiveFile
+	pos int64
+}
+
+func (r *archiveStream) Stat() (fs.FileInfo, error) { return r.f, nil }
+
+func (r *archiveStream) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *archiveStream) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.f.size
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: r.f.name, Err: fs.ErrInvalid}
+	}
+	if offset < r.pos {
+		rc, err := r.f.zf.Open()
+		if err != nil {
+			return 0, &fs.PathError{Op: "seek", Path: r.f.name, Err: err}
+		}
+		r.ReadCloser.Close()
+		r.ReadCloser, r.pos = rc, 0
+	}
+	if _, err := io.CopyN(io.Discard, r.ReadCloser, offset-r.pos); err != nil && err != io.EOF {
+		return 0, &fs.PathError{Op: "seek", Path: r.f.name, Err: err}
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+type archiveDir struct {
+	f      *archiveFile
+	fsys   *archiveFS
+	offset int
+}
+
+func (d *archiveDir) Stat() (fs.FileInfo, error) { return d.f, nil }
+func (d *archiveDir) Close() error               { return nil }
+func (d *archiveDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.f.name, Err: errors.New("is a directory")}
+}
+
+func (d *archiveDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	list := d.fsys.children[d.f.name][d.offset:]
+	if count > 0 && len(list) == 0 {
+		return nil, io.EOF
+	}
+	if count > 0 && len(list) > count {
+		list = list[:count]
+	}
+	d.offset += len(list)
+	res := make([]fs.DirEntry, len(list))
+	for i, c := range list {
+		res[i] = c
+	}
+	return res, nil
+}
+
+// zlibMember frames the raw deflate data of a zip member as zlib (RFC 1950),
+// which is what Content-Encoding: deflate means. Only the Adler-32 trailer needs
+// the uncompressed data; it is computed once on first use.
+type zlibMember struct {
+	zf   *zip.File
+	once sync.Once
+	ra   io.ReaderAt
+	err  error
+}
+
+// zlib header: deflate with 32K window, default level, (0x78<<8|0x9c)%31 == 0
+var zlibHeader = []byte{0x78, 0x9c}
+
+func (z *zlibMember) size() int64 {
+	return int64(len(zlibHeader)) + int64(z.zf.CompressedSize64) + 4
+}
+
+func (z *zlibMember) readerAt() (io.ReaderAt, error) {
+	z.once.Do(func() {
+		rc, err := z.zf.Open()
+		if err != nil {
+			z.err = err
+			return
+		}
+		defer rc.Close()
+		sum := adler32.New()
+		if _, err := io.Copy(sum, rc); err != nil {
+			z.err = err
+			return
+		}
+		raw, err := z.zf.OpenRaw()
+		if err != nil {
+			z.err = err
+			return
+		}
+		rawAt, ok := raw.(io.ReaderAt)
+		if !ok {
+			z.err = errors.New("raw zip member is not seekable")
+			return
+		}
+		trailer := binary.BigEndian.AppendUint32(nil, sum.Sum32())
+		z.ra = &concatReaderAt{parts: []io.ReaderAt{bytes.NewReader(zlibHeader), rawAt, bytes.NewReader(trailer)},
+			sizes: []int64{int64(len(zlibHeader)), int64(z.zf.CompressedSize64), 4}}
+	})
+	return z.ra, z.err
+}
+
+// concatReaderAt joins several ReaderAt of known sizes.
+type concatReaderAt struct {
+	parts []io.ReaderAt
+	sizes []int64
+}
+
+func (c *concatReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	total := 0
+	for i, part := range c.parts {
+		if len(p) == 0 {
+			break
+		}
+		if off >= c.sizes[i] {
+			off -= c.sizes[i]
+			continue
+		}
+		want := c.sizes[i] - off
+		if want > int64(len(p)) {
+			want = int64(len(p))
+		}
+		n, err := part.ReadAt(p[:want], off)
+		total += n
+		if n < int(want) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return total, err
+		}
+		p = p[n:]
+		off = 0
+	}
+	if len(p) != 0 {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+func loadZip(ra io.ReaderAt, size int64) (*archiveFS, error) {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, err
+	}
+	afs := newArchiveFS()
+	for _, zf := range zr.File {
+		name, ok := cleanMemberName(zf.Name)
+		if !ok {
+			slog.Warn("skip unsafe archive member", "name", zf.Name)
+			continue
+		}
+		info := zf.FileInfo()
+		f := &archiveFile{name: name, mode: info.Mode(), modTime: info.ModTime(), size: info.Size()}
+		switch {
+		case info.IsDir():
+			f.mode = fs.ModeDir | 0o555
+			f.size = 0
+		case !info.Mode().IsRegular():
+			slog.Debug("skip non-regular archive member", "name", zf.Name, "mode", info.Mode())
+			continue
+		case zf.Method == zip.Store:
+			raw, err := zf.OpenRaw()
+			if err != nil {
+				return nil, err
+			}
+			if rawAt, ok := raw.(io.ReaderAt); ok {
+				f.ra = rawAt
+				break
+			}
+			f.zf = zf
+		default:
+			f.zf = zf
+		}
+		afs.add(f)
+	}
+	afs.finish()
+	return afs, nil
+}
+
+// posReader tracks the read position so tar member data can be located in the file.
+type posReader struct {
+	r   io.ReadSeeker
+	pos int64
+}
+
+func (p *posReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.pos += int64(n)
+	return n, err
+}
+
+func (p *posReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := p.r.Seek(offset, whence)
+	if err == nil {
+		p.pos = pos
+	}
+	return pos, err
+}
+
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+func tarMember(hdr *tar.Header) (*archiveFile, bool) {
+	name, ok := cleanMemberName(hdr.Name)
+	if !ok {
+		slog.Warn("skip unsafe archive member", "name", hdr.Name)
+		return nil, false
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return &archiveFile{name: name, mode: fs.ModeDir | 0o555, modTime: hdr.ModTime}, true
+	case tar.TypeReg, '\x00': // '\x00' is the old-style regular file
+		if isSparse(hdr) {
+			slog.Warn("skip sparse archive member", "name", hdr.Name)
+			return nil, false
+		}
+		return &archiveFile{name: name, mode: fs.FileMode(hdr.Mode).Perm(), modTime: hdr.ModTime, size: hdr.Size}, true
+	}
+	slog.Debug("skip non-regular archive member", "name", hdr.Name, "type", hdr.Typeflag)
+	return nil, false
+}
+
+// loadTar indexes an uncompressed tar; member data is read in place from ra.
+func loadTar(pr *posReader, ra io.ReaderAt) (*archiveFS, error) {
+	afs := newArchiveFS()
+	tr := tar.NewReader(pr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		f, ok := tarMember(hdr)
+		if !ok {
+			continue
+		}
+		if !f.IsDir() {
+			f.ra = ra
+			f.offset = pr.pos
+		}
+		afs.add(f)
+	}
+	afs.finish()
+	return afs, nil
+}
+
+// loadTarGz reads a gzip-compressed tar into memory.
+func loadTarGz(r io.Reader) (*archiveFS, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	afs := newArchiveFS()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		f, ok := tarMember(hdr)
+		if !ok {
+			continue
+		}
+		if !f.IsDir() {
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			f.ra = bytes.NewReader(data)
+			f.size = int64(len(data))
+		}
+		afs.add(f)
+	}
+	afs.finish()
+	return afs, nil
+}
@@ -0,0 +1,274 @@
+package anystatic
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var archiveMembers = map[string]string{
+	"index.html":     "<html>index</html>",
+	"css/style.css":  strings.Repeat("body { color: red; }\n", 50),
+	"docs/readme.md": "# readme",
+}
+
+func writeZip(t *testing.T, name string) string {
+	t.Helper()
+	fname := filepath.Join(t.TempDir(), name)
+	fp, err := os.Create(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	zw := zip.NewWriter(fp)
+	for name, data := range archiveMembers {
+		method := zip.Store
+		if strings.HasSuffix(name, ".css") {
+			method = zip.Deflate
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Unix(1700000000, 0)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+	if _, err := zw.Create("../evil.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func writeTar(t *testing.T, w io.Writer) {
+	t.Helper()
+	tw := tar.NewWriter(w)
+	tw.WriteHeader(&tar.Header{Name: "./css/", Typeflag: tar.TypeDir, Mode: 0o755})
+	for name, data := range archiveMembers {
+		hdr := &tar.Header{Name: "./" + name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(data)), ModTime: time.Unix(1700000000, 0)}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(data))
+	}
+	tw.WriteHeader(&tar.Header{Name: "link.html", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func archiveFiles(t *testing.T) map[string]string {
+	t.Helper()
+	dir := t.TempDir()
+	tarName := filepath.Join(dir, "site.tar")
+	var buf bytes.Buffer
+	writeTar(t, &buf)
+	if err := os.WriteFile(tarName, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var gzbuf bytes.Buffer
+	gw := gzip.NewWriter(&gzbuf)
+	gw.Write(buf.Bytes())
+	gw.Close()
+	tgzName := filepath.Join(dir, "site.tgz")
+	if err := os.WriteFile(tgzName, gzbuf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]string{"zip": writeZip(t, "site.zip"), "tar": tarName, "tgz": tgzName}
+}
+
+// TestOpenArchive tests every archive type passes the fs.FS conformance checks
+func TestOpenArchive(t *testing.T) {
+	for kind, name := range archiveFiles(t) {
+		fsys, err := OpenArchive(name)
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		expected := []string{"index.html", "css/style.css", "docs/readme.md"}
+		if err := fstest.TestFS(fsys, expected...); err != nil {
+			t.Errorf("%s: %v", kind, err)
+		}
+		for member, data := range archiveMembers {
+			got, err := fs.ReadFile(fsys, member)
+			if err != nil || string(got) != data {
+				t.Errorf("%s %s: unexpected content %q (%v)", kind, member, got, err)
+			}
+		}
+		for _, bad := range []string{"evil.txt", "../evil.txt", "link.html"} {
+			if _, err := fsys.Stat(bad); err == nil {
+				t.Errorf("%s: unexpected member %s", kind, bad)
+			}
+		}
+	}
+}
+
+// TestOpenArchive_Error tests broken archives are reported
+func TestOpenArchive_Error(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "broken.zip")
+	os.WriteFile(name, []byte("not a zip"), 0o644)
+	if _, err := OpenArchive(name); err == nil {
+		t.Errorf("expected error")
+	}
+	if _, err := OpenRoot(name, SymlinkFollow); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+// TestOpenArchive_ZipDeflate tests a deflated zip member is offered as a deflate variant
+func TestOpenArchive_ZipDeflate(t *testing.T) {
+	fsys, err := OpenArchive(writeZip(t, "site.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat("index.html.deflate"); err == nil {
+		t.Errorf("unexpected deflate variant of a stored member")
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/css/style.css", nil)
+	req.Header.Set("Accept-Encoding", "deflate")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ce := w.Header().Get("Content-Encoding"); ce != "deflate" {
+		t.Fatalf("expected deflate encoding, got %q", ce)
+	}
+	zr, err := zlib.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != archiveMembers["css/style.css"] {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	req = httptest.NewRequest("GET", "/css/style.css", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ce := w.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("unexpected encoding %q", ce)
+	}
+	if w.Body.String() != archiveMembers["css/style.css"] {
+		t.Errorf("unexpected identity body")
+	}
+}
+
+// TestOpenArchive_ZipDeflateExt tests the deflate variant follows the handler's encodings
+func TestOpenArchive_ZipDeflateExt(t *testing.T) {
+	name := writeZip(t, "site.zip")
+	testCases := []struct {
+		encodings []Encoding
+		variant   string
+		encoding  string
+	}{
+		{[]Encoding{{Name: "deflate", Ext: ".zz"}}, "css/style.css.zz", "deflate"},
+		{[]Encoding{{Name: "gzip", Ext: ".gz"}}, "", ""},
+	}
+	for _, tc := range testCases {
+		fsys, err := OpenArchive(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := NewHandler(fsys, WithEncodings(tc.encodings...))
+		if _, err := fsys.Stat("css/style.css.deflate"); err == nil {
+			t.Errorf("%v: unexpected .deflate variant", tc.encodings)
+		}
+		if tc.variant != "" {
+			if _, err := fsys.Stat(tc.variant); err != nil {
+				t.Errorf("%v: expected variant %s: %v", tc.encodings, tc.variant, err)
+			}
+		}
+		ents, err := fs.ReadDir(fsys, "css")
+		if err != nil || len(ents) != 1 || ents[0].Name() != "style.css" {
+			t.Errorf("%v: expected only the member listed, got %v", tc.encodings, ents)
+		}
+
+		req := httptest.NewRequest("GET", "/css/style.css", nil)
+		req.Header.Set("Accept-Encoding", "deflate, gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if ce := w.Header().Get("Content-Encoding"); ce != tc.encoding {
+			t.Errorf("%v: expected encoding %q, got %q", tc.encodings, tc.encoding, ce)
+		}
+	}
+}
+
+// TestServeHTTP_ZipRange tests range requests on a compressed zip member
+func TestServeHTTP_ZipRange(t *testing.T) {
+	fsys, err := OpenArchive(writeZip(t, "site.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(fsys)
+	data := archiveMembers["css/style.css"]
+
+	req := httptest.NewRequest("HEAD", "/css/style.css", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ar := w.Header().Get("Accept-Ranges"); ar != "bytes" {
+		t.Errorf("unexpected Accept-Ranges %q", ar)
+	}
+
+	req = httptest.NewRequest("GET", "/css/style.css", nil)
+	req.Header.Set("Range", "bytes=100-104")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != data[100:105] {
+		t.Errorf("unexpected range response %d %q", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/css/style.css", nil)
+	req.Header.Set("Range", "bytes=500-509,0-9")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	body := w.Body.String()
+	if w.Code != http.StatusPartialContent || !strings.Contains(body, data[500:510]) || !strings.Contains(body, data[0:10]) {
+		t.Errorf("unexpected multi-range response %d %q", w.Code, body)
+	}
+}
+
+// TestServeHTTP_Archive tests index, listing and range requests from a tar archive
+func TestServeHTTP_Archive(t *testing.T) {
+	fsys, err := OpenRoot(archiveFiles(t)["tar"], SymlinkFollow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(fsys, WithAutoIndex(true))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Body.String() != archiveMembers["index.html"] {
+		t.Errorf("unexpected index %q", w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/docs/readme.md", nil)
+	req.Header.Set("Range", "bytes=2-")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "readme" {
+		t.Errorf("unexpected range response %d %q", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/docs/?format=json", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"readme.md"`) {
+		t.Errorf("unexpected listing %s", w.Body.String())
+	}
+}
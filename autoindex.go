@@ -0,0 +1,159 @@
+package anystatic
+
+import (
+	"encoding/json"
+	"html"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithAutoIndex enables directory listings for directories without an index file.
+// The listing is HTML, or JSON with "Accept: application/json" or "?format=json".
+func WithAutoIndex(enabled bool) HandlerOption {
+	return func(h *Handler) {
+		h.autoIndex = enabled
+	}
+}
+
+type dirEntry struct {
+	Name      string    `json:"name"`
+	IsDir     bool      `json:"is_dir"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mtime"`
+	Encodings []string  `json:"encodings,omitempty"`
+}
+
+type dirListing struct {
+	Path    string     `json:"path"`
+	Entries []dirEntry `json:"entries"`
+}
+
+// readDirIndex lists dir, hiding pre-compressed siblings of other files
+// and reporting them as encodings of the original instead.
+func (h *Handler) readDirIndex(dir string) ([]dirEntry, error) {
+	name := strings.TrimSuffix(dir, "/")
+	if name == "" {
+		name = "."
+	}
+	ents, err := fs.ReadDir(h.fs, name)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(ents))
+	for _, ent := range ents {
+		names[ent.Name()] = !ent.IsDir()
+	}
+	res := make([]dirEntry, 0, len(ents))
+	for _, ent := range ents {
+		if !ent.IsDir() && h.isEncodedSibling(ent.Name(), names) {
+			continue
+		}
+		if h.denied(dir + ent.Name()) {
+			continue
+		}
+		info, err := ent.Info()
+		if err != nil {
+			slog.Warn("stat failed in listing", "dir", dir, "name", ent.Name(), "error", err)
+			continue
+		}
+		de := dirEntry{Name: ent.Name(), IsDir: ent.IsDir(), ModTime: info.ModTime().UTC()}
+		if !ent.IsDir() {
+			de.Size = info.Size()
+			vfs := h.variantFS(dir + ent.Name())
+			for _, ei := range h.encodings {
+				if !names[ent.Name()+ei.ext] {
+					continue
+				}
+				if _, layered := h.fs.(layeredFS); layered {
+					if _, err := vfs.Stat(dir + ent.Name() + ei.ext); err != nil {
+						continue
+					}
+				}
+				de.Encodings = append(de.Encodings, ei.encode)
+			}
+		}
+		res = append(res, de)
+	}
+	return res, nil
+}
+
+// isEncodedSibling reports whether name is a pre-compressed variant of another file in files.
+func (h *Handler) isEncodedSibling(name string, files map[string]bool) bool {
+	for _, ei := range h.encodings {
+		if base, ok := strings.CutSuffix(name, ei.ext); ok && files[base] {
+			return true
+		}
+	}
+	return false
+}
+
+func wantsJSON(req *http.Request) bool {
+	if req.URL.Query().Get("format") == "json" {
+		return true
+	}
+	return strings.Contains(req.Header.Get("Accept"), "application/json")
+}
+
+// renderDirHTML lists entries; parent adds a "../" link (not at the root or mount point).
+func renderDirHTML(urlPath string, parent bool, entries []dirEntry) []byte {
+	var b strings.Builder
+	title := html.EscapeString(urlPath)
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>Index of " + title + "</title></head>\n<body>\n")
+	b.WriteString("<h1>Index of " + title + "</h1>\n<table>\n")
+	b.WriteString("<tr><th>Name</th><th>Size</th><th>Last modified</th><th>Encodings</th></tr>\n")
+	if parent {
+		b.WriteString("<tr><td><a href=\"../\">../</a></td><td></td><td></td><td></td></tr>\n")
+	}
+	for _, ent := range entries {
+		name := ent.Name
+		size := strconv.FormatInt(ent.Size, 10)
+		if ent.IsDir {
+			name += "/"
+			size = "-"
+		}
+		href := (&url.URL{Path: name}).String()
+		if strings.Contains(name, ":") {
+			// keep "a:b" from being read as a scheme
+			href = "./" + href
+		}
+		b.WriteString("<tr><td><a href=\"" + html.EscapeString(href) + "\">" + html.EscapeString(name) + "</a></td>")
+		b.WriteString("<td>" + size + "</td>")
+		b.WriteString("<td>" + ent.ModTime.Format(time.RFC3339) + "</td>")
+		b.WriteString("<td>" + html.EscapeString(strings.Join(ent.Encodings, ", ")) + "</td></tr>\n")
+	}
+	b.WriteString("</table>\n</body>\n</html>\n")
+	return []byte(b.String())
+}
+
+// serveDirIndex writes the listing of dir. ok is false when dir is not a readable directory.
+func (h *Handler) serveDirIndex(res http.ResponseWriter, req *http.Request, dir string) (code int, ok bool) {
+	entries, err := h.readDirIndex(dir)
+	if err != nil {
+		slog.Debug("no directory listing", "dir", dir, "error", err)
+		return 0, false
+	}
+	var body []byte
+	if wantsJSON(req) {
+		body, err = json.Marshal(dirListing{Path: req.URL.Path, Entries: entries})
+		if err != nil {
+			slog.Error("json marshal failed", "dir", dir, "error", err)
+			return h.fail(res, req, http.StatusInternalServerError), true
+		}
+		res.Header().Set("Content-Type", "application/json")
+	} else {
+		body = renderDirHTML(req.URL.Path, dir != "", entries)
+		res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	res.Header().Set("Vary", "Accept")
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.WriteHeader(http.StatusOK)
+	if req.Method != http.MethodHead {
+		res.Write(body)
+	}
+	return http.StatusOK, true
+}
@@ -0,0 +1,159 @@
+package anystatic
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// TestServeHTTP_AutoIndexJSON tests the JSON listing and hidden compressed siblings
+func TestServeHTTP_AutoIndexJSON(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"files/a.txt":     &fstest.MapFile{Data: []byte("aaaaaaaa"), ModTime: modTime},
+		"files/a.txt.gz":  &fstest.MapFile{Data: []byte("gz"), ModTime: modTime},
+		"files/a.txt.br":  &fstest.MapFile{Data: []byte("br"), ModTime: modTime},
+		"files/only.gz":   &fstest.MapFile{Data: []byte("archive"), ModTime: modTime},
+		"files/<x>.txt":   &fstest.MapFile{Data: []byte("x"), ModTime: modTime},
+		"files/sub/b.txt": &fstest.MapFile{Data: []byte("b"), ModTime: modTime},
+	}
+	h := NewHandler(fsys, WithAutoIndex(true))
+
+	req := httptest.NewRequest("GET", "/files/", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected Content-Type: %q", ct)
+	}
+	var listing dirListing
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatal(err)
+	}
+	if listing.Path != "/files/" {
+		t.Errorf("unexpected path: %q", listing.Path)
+	}
+	expected := []dirEntry{
+		{Name: "<x>.txt", Size: 1},
+		{Name: "a.txt", Size: 8, Encodings: []string{"br", "gzip"}},
+		{Name: "only.gz", Size: 7},
+		{Name: "sub", IsDir: true},
+	}
+	if len(listing.Entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %+v", len(expected), listing.Entries)
+	}
+	for i, exp := range expected {
+		got := listing.Entries[i]
+		if got.Name != exp.Name || got.IsDir != exp.IsDir || (!exp.IsDir && got.Size != exp.Size) {
+			t.Errorf("entry %d: expected %+v, got %+v", i, exp, got)
+		}
+		if strings.Join(got.Encodings, ",") != strings.Join(exp.Encodings, ",") {
+			t.Errorf("entry %d: expected encodings %v, got %v", i, exp.Encodings, got.Encodings)
+		}
+		if !exp.IsDir && !got.ModTime.Equal(modTime) {
+			t.Errorf("entry %d: unexpected mtime %v", i, got.ModTime)
+		}
+	}
+}
+
+// TestServeHTTP_AutoIndexHTML tests the HTML listing escapes names
+func TestServeHTTP_AutoIndexHTML(t *testing.T) {
+	fsys := fstest.MapFS{
+		"files/a.txt":     &fstest.MapFile{Data: []byte("aaaaaaaa")},
+		"files/a.txt.gz":  &fstest.MapFile{Data: []byte("gz")},
+		"files/a.txt.br":  &fstest.MapFile{Data: []byte("br")},
+		"files/<x>.txt":   &fstest.MapFile{Data: []byte("x")},
+		"files/sub/b.txt": &fstest.MapFile{Data: []byte("b")},
+	}
+	h := NewHandler(fsys, WithAutoIndex(true))
+
+	req := httptest.NewRequest("GET", "/files/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("unexpected Content-Type: %q", ct)
+	}
+	body := w.Body.String()
+	for _, want := range []string{`<a href="a.txt">a.txt</a>`, `<a href="sub/">sub/</a>`, `&lt;x&gt;.txt`, `<a href="../">`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in listing", want)
+		}
+	}
+	for _, unwanted := range []string{"a.txt.gz", "a.txt.br", "<x>"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("unexpected %q in listing", unwanted)
+		}
+	}
+}
+
+// TestServeHTTP_AutoIndexQuery tests ?format=json
+func TestServeHTTP_AutoIndexQuery(t *testing.T) {
+	fsys := fstest.MapFS{
+		"files/a.txt":     &fstest.MapFile{Data: []byte("aaaaaaaa")},
+		"files/sub/b.txt": &fstest.MapFile{Data: []byte("b")},
+	}
+	h := NewHandler(fsys, WithAutoIndex(true))
+
+	req := httptest.NewRequest("GET", "/files/sub/?format=json", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	var listing dirListing
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Entries) != 1 || listing.Entries[0].Name != "b.txt" {
+		t.Errorf("unexpected entries: %+v", listing.Entries)
+	}
+}
+
+// TestServeHTTP_AutoIndexPrefersIndex tests index.html wins over the listing
+func TestServeHTTP_AutoIndexPrefersIndex(t *testing.T) {
+	fsys := fstest.MapFS{
+		"site/index.html": &fstest.MapFile{Data: []byte("index")},
+	}
+	h := NewHandler(fsys, WithAutoIndex(true))
+
+	req := httptest.NewRequest("GET", "/site/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if body := w.Body.String(); body != "index" {
+		t.Errorf("expected index.html, got %q", body)
+	}
+
+	req = httptest.NewRequest("GET", "/nodir/", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// TestServeHTTP_AutoIndexDisabled tests 404 without the option
+func TestServeHTTP_AutoIndexDisabled(t *testing.T) {
+	fsys := fstest.MapFS{
+		"files/a.txt": &fstest.MapFile{Data: []byte("aaaaaaaa")},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/files/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/wtnb75/anystatic"
+)
+
+// anystatic-embed copies a site directory (with its pre-compressed siblings)
+// into an output directory and writes Go sources embedding it:
+//   - site/: the copied tree, embedded with //go:embed all:site
+//   - hashes.go: content hashes computed now, used as ETags since embedded files have no mtime
+//   - main.go (package main) or site.go (other packages)
+
+const header = "// Code generated by anystatic-embed; DO NOT EDIT.\n\n"
+
+const mainTemplate = `package main
+
+import (
+	"embed"
+	"flag"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/wtnb75/anystatic"
+)
+
+//go:embed all:site
+var site embed.FS
+
+func main() {
+	listen := flag.String("listen", ":8800", "listen address")
+	autoIndex := flag.Bool("autoindex", false, "list directories without index.html")
+	flag.Parse()
+	root, err := fs.Sub(site, "site")
+	if err != nil {
+		slog.Error("embedded site", "error", err)
+		os.Exit(1)
+	}
+	fsys, err := anystatic.NewHashFS(root, contentHashes)
+	if err != nil {
+		slog.Error("embedded site", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("starting server", "addr", *listen)
+	if err := http.ListenAndServe(*listen, anystatic.NewHandler(fsys, anystatic.WithAutoIndex(*autoIndex))); err != nil {
+		slog.Error("server error", "error", err)
+		os.Exit(1)
+	}
+}
+`
+
+const packageTemplate = `package %s
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/wtnb75/anystatic"
+)
+
+//go:embed all:site
+var site embed.FS
+
+// FS returns the embedded site.
+func FS() (*anystatic.HashFS, error) {
+	root, err := fs.Sub(site, "site")
+	if err != nil {
+		return nil, err
+	}
+	return anystatic.NewHashFS(root, contentHashes)
+}
+
+// Handler returns an anystatic handler serving the embedded site.
+func Handler(opts ...anystatic.HandlerOption) (http.Handler, error) {
+	fsys, err := FS()
+	if err != nil {
+		return nil, err
+	}
+	return anystatic.NewHandler(fsys, opts...), nil
+}
+`
+
+// embeddable reports whether go:embed accepts name as a path element.
+func embeddable(name string) bool {
+	return !strings.ContainsAny(name, "\"*<>?`'|\\:")
+}
+
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0o200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyTree copies src to dst, skipping the directory skip (absolute, e.g. the output directory).
+func copyTree(src, dst, skip string) (int, error) {
+	count := 0
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path == skip {
+			slog.Debug("skip output directory", "path", path)
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && !embeddable(d.Name()) {
+			slog.Warn("skip, name cannot be embedded", "path", path)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			slog.Warn("skip non-regular file", "path", path, "mode", info.Mode())
+			return nil
+		}
+		count++
+		return copyFile(path, target, info.Mode())
+	})
+	return count, err
+}
+
+func writeSource(name string, src []byte) error {
+	formatted, err := format.Source(src)
+	if err != nil {
+		return fmt.Errorf("format %s: %w", name, err)
+	}
+	return os.WriteFile(name, formatted, 0o644)
+}
+
+func hashesSource(pkg string, hashes map[string]string) []byte {
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	b.WriteString(header)
+	fmt.Fprintf(&b, "package %s\n\n// contentHashes maps embedded paths to their content hash.\nvar contentHashes = map[string]string{\n", pkg)
+	for _, name := range names {
+		fmt.Fprintf(&b, "\t%q: %q,\n", name, hashes[name])
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// generated reports whether out holds a previous output of anystatic-embed.
+func generated(out string) bool {
+	fp, err := os.Open(filepath.Join(out, "hashes.go"))
+	if err != nil {
+		return false
+	}
+	defer fp.Close()
+	buf := make([]byte, len(header))
+	if _, err := io.ReadFull(fp, buf); err != nil {
+		return false
+	}
+	return string(buf) == header
+}
+
+// within reports whether path is base or lies below it.
+func within(path, base string) bool {
+	rel, err := filepath.Rel(base, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// generate copies dir into out/site and writes the sources of package pkg into out.
+// out may lie inside dir (as with the defaults); it is not copied into itself.
+// The site is copied into a temporary directory first, and an existing out/site is
+// replaced only when out holds a previous output.
+func generate(dir, out, pkg string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	out, err = filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	siteDir := filepath.Join(out, "site")
+	if dir == out || within(dir, siteDir) {
+		return fmt.Errorf("site directory %s would be overwritten by the output in %s", dir, out)
+	}
+	if _, err := os.Lstat(siteDir); err == nil && !generated(out) {
+		return fmt.Errorf("%s exists and was not generated by anystatic-embed", siteDir)
+	}
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(out, ".site-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := os.Chmod(tmpDir, 0o755); err != nil {
+		return err
+	}
+	count, err := copyTree(dir, tmpDir, out)
+	if err != nil {
+		slog.Error("copy site", "dir", dir, "error", err)
+		return err
+	}
+	if err := os.RemoveAll(siteDir); err != nil {
+		slog.Error("cleanup", "dir", siteDir, "error", err)
+		return err
+	}
+	if err := os.Rename(tmpDir, siteDir); err != nil {
+		return err
+	}
+	hashes, err := anystatic.ContentHashes(os.DirFS(siteDir))
+	if err != nil {
+		slog.Error("content hash", "dir", siteDir, "error", err)
+		return err
+	}
+	if err := writeSource(filepath.Join(out, "hashes.go"), hashesSource(pkg, hashes)); err != nil {
+		slog.Error("write hashes", "error", err)
+		return err
+	}
+	srcName, src := "main.go", mainTemplate
+	if pkg != "main" {
+		srcName, src = "site.go", fmt.Sprintf(packageTemplate, pkg)
+	}
+	if err := writeSource(filepath.Join(out, srcName), []byte(header+src)); err != nil {
+		slog.Error("write source", "error", err)
+		return err
+	}
+	slog.Info("embedded site generated", "dir", dir, "out", out, "files", count, "package", pkg)
+	return nil
+}
+
+func realMain() error {
+	dir := flag.String("dir", ".", "site directory to embed")
+	out := flag.String("out", "embedded", "output directory for generated sources and the copied site")
+	pkg := flag.String("package", "main", "package name; main generates a server, others a package with FS and Handler")
+	verbose := flag.Bool("verbose", false, "enable verbose logging")
+	flag.Parse()
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	return generate(*dir, *out, *pkg)
+}
+
+func main() {
+	if err := realMain(); err != nil {
+		slog.Error("generate error", "error", err)
+		os.Exit(1)
+	}
+}
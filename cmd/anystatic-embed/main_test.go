@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestGenerate_Default tests the default flags, where the output directory is inside the site
+func TestGenerate_Default(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "site"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "site", "index.html"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	// twice: the second run must not copy the first output
+	for range 2 {
+		if err := generate(".", "embedded", "main"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "embedded", "site", "site", "index.html")); err != nil {
+		t.Errorf("expected the site copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "embedded", "site", "embedded")); !os.IsNotExist(err) {
+		t.Errorf("expected the output directory not copied, got %v", err)
+	}
+	for _, name := range []string{"main.go", "hashes.go"} {
+		if _, err := os.Stat(filepath.Join(dir, "embedded", name)); err != nil {
+			t.Errorf("expected %s: %v", name, err)
+		}
+	}
+	if err := generate(".", ".", "main"); err == nil {
+		t.Errorf("expected error for output in place of the site")
+	}
+}
+
+// TestGenerate_SiteInOutput tests a site directory that would be replaced by the output is refused
+func TestGenerate_SiteInOutput(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "site", "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "site", "index.html"), []byte("index"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	for _, site := range []string{"site", "site/sub"} {
+		if err := generate(site, ".", "main"); err == nil {
+			t.Errorf("%s: expected error", site)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "site", "index.html")); err != nil || string(data) != "index" {
+		t.Errorf("expected the site kept, got %q (%v)", data, err)
+	}
+}
+
+// TestGenerate_ExistingSite tests an output site not written by a previous run is kept
+func TestGenerate_ExistingSite(t *testing.T) {
+	dir := t.TempDir()
+	src, out := filepath.Join(dir, "src"), filepath.Join(dir, "out")
+	for _, name := range []string{filepath.Join(src, "index.html"), filepath.Join(out, "site", "keep.txt")} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := generate(src, out, "main"); err == nil {
+		t.Errorf("expected error for an existing site")
+	}
+	if _, err := os.Stat(filepath.Join(out, "site", "keep.txt")); err != nil {
+		t.Errorf("expected the existing site kept: %v", err)
+	}
+
+	// a previous output is replaced
+	os.RemoveAll(filepath.Join(out, "site"))
+	for range 2 {
+		if err := generate(src, out, "main"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "site", "index.html")); err != nil {
+		t.Errorf("expected the site copied: %v", err)
+	}
+	if ents, _ := filepath.Glob(filepath.Join(out, ".site-*")); len(ents) != 0 {
+		t.Errorf("unexpected temporary directories %v", ents)
+	}
+}
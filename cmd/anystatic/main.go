@@ -2,13 +2,14 @@ package main
 
 import (
 	"flag"
-	"io/fs"
+	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 
 	"github.com/wtnb75/anystatic"
 )
@@ -22,11 +23,56 @@ func do_listen(listen string) (net.Listener, error) {
 	return net.Listen("tcp", listen)
 }
 
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 func realMain() error {
+	var dirs, errorPages, deny, allow, headers, mimeTypes, charsets stringList
 	listen := flag.String("listen", ":8800", "listen address")
-	dir := flag.String("dir", ".", "serve directory")
+	flag.Var(&dirs, "dir", "serve directory or .zip/.tar/.tar.gz archive (default .), may be repeated to layer roots, first on top")
 	verbose := flag.Bool("verbose", false, "enable verbose logging")
 	accessLogHeaders := flag.Bool("access-log-headers", true, "include request/response headers in access log")
+	encodings := flag.String("encodings", "", "comma separated encodings in priority order, name or name:ext (default br,zstd,gzip,deflate,compress)")
+	methods := flag.String("methods", "", "comma separated methods served like GET, besides GET and HEAD")
+	spa := flag.String("spa", "", "single-page application fallback document for missing paths without extension (e.g. /index.html)")
+	autoIndex := flag.Bool("autoindex", false, "list directories without index.html (HTML, or JSON with ?format=json)")
+	indexFiles := flag.String("index", "index.html", "comma separated index files tried in order for directories")
+	tryFiles := flag.String("try-files", "", "comma separated try_files candidates, $uri is the request path (e.g. $uri,$uri.html,$uri/)")
+	flag.Var(&errorPages, "error-page", "error document, code[,code...]=document (e.g. 404=/404.html), may be repeated")
+	denyDotfiles := flag.Bool("deny-dotfiles", true, "block paths with an element starting with '.' (except .well-known)")
+	flag.Var(&deny, "deny", "block paths matching glob, or regexp with ~ prefix, may be repeated")
+	flag.Var(&allow, "allow", "permit paths matching glob, or regexp with ~ prefix, even if denied, may be repeated")
+	symlinks := flag.String("symlinks", "follow", "symlink policy: follow, deny or within-root")
+	denyStatus := flag.Int("deny-status", 404, "status for blocked paths (404 or 403)")
+	prefix := flag.String("prefix", "", "URL path prefix removed before lookup (e.g. /static), other paths get 404")
+	metaCache := flag.Int("meta-cache", 0, "number of files whose stat, variants and content type are cached (0 disables)")
+	metaCacheTTL := flag.Duration("meta-cache-ttl", 5*time.Second, "time a metadata cache entry is trusted before the file is checked again")
+	negCache := flag.Int("negative-cache", 0, "number of missing paths remembered (0 disables)")
+	negCacheTTL := flag.Duration("negative-cache-ttl", 2*time.Second, "time a missing path is remembered")
+	fileCache := flag.Int64("file-cache", 0, "bytes of small files kept in memory (0 disables)")
+	fileCacheMaxFile := flag.Int64("file-cache-max-file", 1<<20, "largest file kept in the file cache, in bytes")
+	mimeTypesFile := flag.String("mime-types", "", "mime.types file adding content types by extension (e.g. /etc/mime.types)")
+	flag.Var(&mimeTypes, "mime-type", "content type of an extension, ext=type (e.g. .webc=text/html), may be repeated")
+	flag.Var(&charsets, "charset", "charset of an extension, ext=charset (e.g. .txt=shift_jis, empty removes it), may be repeated")
+	strictMIME := flag.Bool("strict-mime", false, "never sniff content types: unknown extensions are application/octet-stream, with X-Content-Type-Options: nosniff")
+	precompressedOnly := flag.Bool("precompressed-only", false, "serve files whose original is missing from their pre-compressed variants (e.g. app.js.gz for app.js)")
+	compress := flag.Bool("compress", false, "gzip/deflate files without a pre-compressed variant while serving")
+	compressTypes := flag.String("compress-types", "", "comma separated media types to compress, e.g. text/*,application/json (default text, JavaScript, JSON, XML, SVG, wasm)")
+	compressMinSize := flag.Int64("compress-min-size", 256, "smallest file compressed, in bytes")
+	compressMaxSize := flag.Int64("compress-max-size", 8<<20, "largest file compressed, in bytes")
+	compressLevel := flag.Int("compress-level", -1, "compression level, 1 (fastest) to 9 (best), -1 for the default")
+	compressWriteBack := flag.Bool("compress-write-back", false, "store compressed output next to the original (e.g. app.js.gz)")
+	flag.Var(&headers, "header", "response header, Name: value, may be repeated")
+	vhostsFile := flag.String("vhosts-file", "", "JSON file of virtual hosts, {\"vhosts\": [{\"hosts\": [...], \"rootdir\": ...}]}")
+	vhostsDir := flag.String("vhosts-dir", "", "directory with one root per host, e.g. /srv/<host>/ (default/ for unknown hosts)")
 	flag.Parse()
 	level := slog.LevelInfo
 	if *verbose {
@@ -35,8 +81,151 @@ func realMain() error {
 	slog.SetLogLoggerLevel(level)
 	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
 
-	fs := os.DirFS(*dir).(fs.StatFS)
-	hdl := anystatic.NewHandler(fs, anystatic.WithAccessLogHeaders(*accessLogHeaders))
+	opts := []anystatic.HandlerOption{anystatic.WithAccessLogHeaders(*accessLogHeaders), anystatic.WithAutoIndex(*autoIndex)}
+	opts = append(opts, anystatic.WithIndexFiles(strings.Split(*indexFiles, ",")...))
+	if *tryFiles != "" {
+		opts = append(opts, anystatic.WithTryFiles(strings.Split(*tryFiles, ",")...))
+	}
+	if *spa != "" {
+		opts = append(opts, anystatic.WithSPAFallback(*spa))
+	}
+	if err := anystatic.CheckDenyStatus(*denyStatus); err != nil {
+		slog.Error("invalid deny status", "deny-status", *denyStatus, "error", err)
+		return err
+	}
+	opts = append(opts, anystatic.WithDenyDotfiles(*denyDotfiles), anystatic.WithDenyStatus(*denyStatus))
+	denyRules, err := anystatic.ParsePathRules(deny)
+	if err != nil {
+		slog.Error("invalid deny rule", "deny", deny, "error", err)
+		return err
+	}
+	allowRules, err := anystatic.ParsePathRules(allow)
+	if err != nil {
+		slog.Error("invalid allow rule", "allow", allow, "error", err)
+		return err
+	}
+	opts = append(opts, anystatic.WithDeny(denyRules...), anystatic.WithAllow(allowRules...))
+	if *metaCache > 0 {
+		opts = append(opts, anystatic.WithMetaCache(*metaCache, *metaCacheTTL))
+	}
+	if *negCache > 0 {
+		opts = append(opts, anystatic.WithNegativeCache(*negCache, *negCacheTTL))
+	}
+	if *fileCache > 0 {
+		opts = append(opts, anystatic.WithFileCache(*fileCache, *fileCacheMaxFile))
+	}
+	if *mimeTypesFile != "" {
+		types, err := anystatic.LoadMIMETypes(*mimeTypesFile)
+		if err != nil {
+			slog.Error("cannot load mime types", "file", *mimeTypesFile, "error", err)
+			return err
+		}
+		opts = append(opts, anystatic.WithMIMETypes(types))
+	}
+	if len(mimeTypes) != 0 {
+		types := map[string]string{}
+		for _, spec := range mimeTypes {
+			ext, ctype, ok := strings.Cut(spec, "=")
+			if !ok || strings.TrimSpace(ext) == "" || strings.TrimSpace(ctype) == "" {
+				slog.Error("invalid mime type", "mime-type", spec)
+				return fmt.Errorf("invalid mime type %q, expected ext=type", spec)
+			}
+			types[ext] = ctype
+		}
+		opts = append(opts, anystatic.WithMIMETypes(types))
+	}
+	if len(charsets) != 0 {
+		cs := map[string]string{}
+		for _, spec := range charsets {
+			ext, charset, ok := strings.Cut(spec, "=")
+			if !ok || strings.TrimSpace(ext) == "" {
+				slog.Error("invalid charset", "charset", spec)
+				return fmt.Errorf("invalid charset %q, expected ext=charset", spec)
+			}
+			cs[ext] = charset
+		}
+		opts = append(opts, anystatic.WithCharsets(cs))
+	}
+	if *strictMIME {
+		opts = append(opts, anystatic.WithStrictMIME(true))
+	}
+	if *precompressedOnly {
+		opts = append(opts, anystatic.WithPrecompressedOnly(true))
+	}
+	if *compress {
+		dc := anystatic.DynamicCompression{MinSize: *compressMinSize, MaxSize: *compressMaxSize, Level: *compressLevel, WriteBack: *compressWriteBack}
+		if *compressTypes != "" {
+			dc.Types = strings.Split(*compressTypes, ",")
+		}
+		opts = append(opts, anystatic.WithDynamicCompression(dc))
+	}
+	if *prefix != "" {
+		opts = append(opts, anystatic.WithPrefix(*prefix))
+	}
+	if len(headers) != 0 {
+		hdrs := map[string]string{}
+		for _, spec := range headers {
+			name, value, ok := strings.Cut(spec, ":")
+			if !ok || strings.TrimSpace(name) == "" {
+				slog.Error("invalid header", "header", spec)
+				return fmt.Errorf("invalid header %q, expected Name: value", spec)
+			}
+			hdrs[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+		opts = append(opts, anystatic.WithResponseHeaders(hdrs))
+	}
+	for _, spec := range errorPages {
+		codes, document, err := anystatic.ParseErrorPage(spec)
+		if err != nil {
+			slog.Error("invalid error page", "error-page", spec, "error", err)
+			return err
+		}
+		opts = append(opts, anystatic.WithErrorPage(document, codes...))
+	}
+	if *methods != "" {
+		opts = append(opts, anystatic.WithMethods(strings.Split(*methods, ",")...))
+	}
+	if *encodings != "" {
+		encs, err := anystatic.ParseEncodings(strings.Split(*encodings, ","))
+		if err != nil {
+			slog.Error("invalid encodings", "encodings", *encodings, "error", err)
+			return err
+		}
+		opts = append(opts, anystatic.WithEncodings(encs...))
+	}
+
+	policy, err := anystatic.ParseSymlinkPolicy(*symlinks)
+	if err != nil {
+		slog.Error("invalid symlink policy", "symlinks", *symlinks, "error", err)
+		return err
+	}
+	var hdl http.Handler
+	if *vhostsFile != "" || *vhostsDir != "" {
+		vh, err := anystatic.LoadVHosts(*vhostsFile, *vhostsDir, policy, opts...)
+		if err != nil {
+			slog.Error("load vhosts", "file", *vhostsFile, "dir", *vhostsDir, "error", err)
+			return err
+		}
+		if len(dirs) != 0 && vh.Default() == nil {
+			fs, err := anystatic.OpenRoots(dirs, policy)
+			if err != nil {
+				slog.Error("open root", "dir", dirs, "error", err)
+				return err
+			}
+			vh.Add("default", anystatic.NewHandler(fs, append(opts, anystatic.WithAccessLogField("vhost", "default"))...))
+		}
+		hdl = vh
+	} else {
+		if len(dirs) == 0 {
+			dirs = stringList{"."}
+		}
+		fs, err := anystatic.OpenRoots(dirs, policy)
+		if err != nil {
+			slog.Error("open root", "dir", dirs, "error", err)
+			return err
+		}
+		hdl = anystatic.NewHandler(fs, opts...)
+	}
 	server := http.Server{
 		Handler: hdl,
 	}
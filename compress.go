@@ -0,0 +1,214 @@
+package anystatic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	pathpkg "path"
+	"strings"
+	"time"
+)
+
+// Defaults of DynamicCompression.
+const (
+	defaultCompressMinSize = 256
+	defaultCompressMaxSize = 8 << 20
+)
+
+// defaultCompressCacheSize bounds the compressed output kept in memory without WithFileCache.
+const defaultCompressCacheSize = 32 << 20
+
+// defaultCompressTypes are the content types compressed when DynamicCompression.Types is empty.
+var defaultCompressTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/*+json",
+	"application/xml",
+	"application/*+xml",
+	"application/wasm",
+	"image/svg+xml",
+	"image/x-icon",
+}
+
+// DynamicCompression configures compression of files that have no usable pre-compressed variant.
+type DynamicCompression struct {
+	// Types are media types (without parameters) to compress, path.Match patterns
+	// such as "text/*" allowed. Empty means text, JavaScript, JSON, XML, SVG and wasm.
+	Types []string
+	// MinSize and MaxSize bound the size of compressed files (default 256 bytes and 8 MiB).
+	MinSize int64
+	MaxSize int64
+	// Level is the compress/flate level (default flate.DefaultCompression).
+	Level int
+	// WriteBack stores the result as a sibling file (e.g. app.js.gz) when the root is a directory,
+	// so later requests take the pre-compressed path.
+	WriteBack bool
+}
+
+// WithDynamicCompression compresses files with gzip or deflate while serving them, when the
+// client accepts one of these (and it is in the encodings, see WithEncodings) but no
+// pre-compressed variant is usable. Whole files are compressed in memory, so Content-Length,
+// ETag and ranges work as for pre-compressed files. The output is kept in the file cache
+// (see WithFileCache), or without one in a cache of its own of 32 MiB, so a file is compressed
+// once while unchanged; files larger than the cache are compressed on every request.
+// A 304 response needs no compression; HEAD compresses as GET does, so both get the same
+// headers. As for pre-compressed files, an output larger than the original is not sent.
+func WithDynamicCompression(dc DynamicCompression) HandlerOption {
+	return func(h *Handler) {
+		if dc.MinSize <= 0 {
+			dc.MinSize = defaultCompressMinSize
+		}
+		if dc.MaxSize <= 0 {
+			dc.MaxSize = defaultCompressMaxSize
+		}
+		types := make([]string, 0, len(dc.Types))
+		for _, t := range dc.Types {
+			if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+				types = append(types, t)
+			}
+		}
+		if len(types) == 0 {
+			types = defaultCompressTypes
+		}
+		dc.Types = types
+		if dc.Level == 0 {
+			dc.Level = gzip.DefaultCompression
+		}
+		h.compression = &dc
+	}
+}
+
+// compressible reports whether a file of content type ctype and size can be compressed.
+func (dc *DynamicCompression) compressible(ctype string, size int64) bool {
+	if size < dc.MinSize || size > dc.MaxSize {
+		return false
+	}
+	mediatype, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range dc.Types {
+		if ok, _ := pathpkg.Match(pattern, mediatype); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compress encodes src with encoding, "gzip" or "deflate" (zlib format, RFC 9110 8.4.1.2).
+func (dc *DynamicCompression) compress(encoding string, src io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	var err error
+	switch encoding {
+	case "gzip":
+		zw, err = gzip.NewWriterLevel(&buf, dc.Level)
+	case "deflate":
+		zw, err = zlib.NewWriterLevel(&buf, dc.Level)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(zw, src); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writableFS is a filesystem that can store the output of dynamic compression.
+type writableFS interface {
+	// WriteFile creates name with data and modification time modTime; an existing file is kept.
+	WriteFile(name string, data []byte, modTime time.Time) error
+}
+
+// dynamicInfo is the file info of a dynamically compressed representation.
+type dynamicInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *dynamicInfo) Size() int64 { return i.size }
+
+// dynamicEncoding returns the first of encs that dynamic compression supports,
+// when the file of meta, whose file info is info, is compressible.
+func (h *Handler) dynamicEncoding(meta *fileMeta, info fs.FileInfo, encs []encodeInfo) (encodeInfo, bool) {
+	if !h.compression.compressible(meta.ctype, info.Size()) {
+		return encodeInfo{}, false
+	}
+	for _, ei := range encs {
+		if ei.encode == "gzip" || ei.encode == "deflate" {
+			return ei, true
+		}
+	}
+	return encodeInfo{}, false
+}
+
+// compressDynamic compresses path, whose file info is info, with ei.
+// It returns false when the file could not be compressed or the output is larger.
+func (h *Handler) compressDynamic(meta *fileMeta, path string, info fs.FileInfo, ei encodeInfo) ([]byte, bool) {
+	// "\x00" never appears in a file name, so the output does not collide with a real variant
+	key := path + "\x00" + ei.encode
+	cacheable := info.Size() <= h.compressCache.maxFileSize
+	if cacheable {
+		if data, ok := h.compressCache.get(key, info); ok {
+			return data, len(data) != 0
+		}
+	}
+	fp, err := meta.vfs.Open(path)
+	if err != nil {
+		slog.Error("open for compression", "path", path, "error", err)
+		return nil, false
+	}
+	data, err := h.compression.compress(ei.encode, io.LimitReader(fp, info.Size()))
+	fp.Close()
+	if err != nil {
+		slog.Error("compression failed", "path", path, "encoding", ei.encode, "error", err)
+		return nil, false
+	}
+	if int64(len(data)) > info.Size() {
+		slog.Info("encoded file is larger than original, skip", "path", path, "encoding", ei.encode, "original", info.Size(), "encoded", len(data))
+		if cacheable {
+			// remember not to try again while the file is unchanged
+			h.compressCache.put(key, info, []byte{})
+		}
+		return nil, false
+	}
+	slog.Debug("compressed", "path", path, "encoding", ei.encode, "original", info.Size(), "encoded", len(data))
+	if cacheable {
+		h.compressCache.put(key, info, data)
+	}
+	if h.compression.WriteBack {
+		h.writeBack(meta, ei.ext, data, info.ModTime())
+	}
+	return data, true
+}
+
+// writeBack stores data as the variant with suffix ext next to the original, when the filesystem
+// allows it. An existing variant (e.g. an outdated one) is left alone.
+func (h *Handler) writeBack(meta *fileMeta, ext string, data []byte, modTime time.Time) {
+	wfs, ok := meta.vfs.(writableFS)
+	if !ok || meta.variant(ext) != nil {
+		return
+	}
+	name := meta.name + ext
+	if err := wfs.WriteFile(name, data, modTime); err != nil {
+		slog.Warn("write back failed", "path", name, "error", err)
+		return
+	}
+	slog.Info("compressed file written", "path", name, "size", len(data))
+	if h.metaCache != nil {
+		// probe the new variant on the next request
+		h.metaCache.remove(meta.name)
+	}
+}
@@ -0,0 +1,276 @@
+package anystatic
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var compressText = strings.Repeat("body { margin: 0; padding: 0; }\n", 64)
+
+func decode(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+	var zr io.ReadCloser
+	var err error
+	switch encoding {
+	case "gzip":
+		zr, err = gzip.NewReader(body)
+	case "deflate":
+		zr, err = zlib.NewReader(body)
+	default:
+		t.Fatalf("unexpected encoding %q", encoding)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestDynamicCompression tests files without a variant are compressed within type and size limits
+func TestDynamicCompression(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.Read(random)
+	fsys := fstest.MapFS{
+		"style.css":    &fstest.MapFile{Data: []byte(compressText)},
+		"small.css":    &fstest.MapFile{Data: []byte("body{}")},
+		"image.png":    &fstest.MapFile{Data: []byte(compressText)},
+		"random.txt":   &fstest.MapFile{Data: random},
+		"static.js":    &fstest.MapFile{Data: []byte(compressText)},
+		"static.js.gz": &fstest.MapFile{Data: []byte("pre-compressed")},
+	}
+	h := NewHandler(fsys, WithDynamicCompression(DynamicCompression{}))
+
+	testCases := []struct {
+		path     string
+		accept   string
+		encoding string
+	}{
+		{"/style.css", "gzip", "gzip"},
+		{"/style.css", "deflate", "deflate"},
+		{"/style.css", "br, deflate;q=0.5, gzip", "gzip"},
+		{"/style.css", "gzip, identity;q=0", "gzip"},
+		{"/style.css", "br", ""},
+		{"/style.css", "", ""},
+		{"/small.css", "gzip", ""},
+		{"/image.png", "gzip", ""},
+		{"/random.txt", "gzip", ""},
+		{"/static.js", "gzip", "gzip"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept-Encoding", tc.accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if ce := w.Header().Get("Content-Encoding"); ce != tc.encoding {
+			t.Errorf("%s %q: expected encoding %q, got %q", tc.path, tc.accept, tc.encoding, ce)
+			continue
+		}
+		if tc.path == "/static.js" && w.Body.String() != "pre-compressed" {
+			t.Errorf("expected pre-compressed variant, got %q", w.Body.String())
+		}
+		if tc.path == "/style.css" && tc.encoding != "" {
+			if w.Header().Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+				t.Errorf("%s %q: content length %s for %d bytes", tc.path, tc.accept, w.Header().Get("Content-Length"), w.Body.Len())
+			}
+			if !strings.HasSuffix(w.Header().Get("ETag"), "-"+tc.encoding+"\"") {
+				t.Errorf("%s %q: unexpected etag %s", tc.path, tc.accept, w.Header().Get("ETag"))
+			}
+			if got := decode(t, tc.encoding, w.Body); got != compressText {
+				t.Errorf("%s %q: unexpected decoded body %q", tc.path, tc.accept, got)
+			}
+		}
+	}
+}
+
+// TestDynamicCompression_Types tests configured content types
+func TestDynamicCompression_Types(t *testing.T) {
+	fsys := fstest.MapFS{
+		"style.css": &fstest.MapFile{Data: []byte(compressText)},
+		"small.css": &fstest.MapFile{Data: []byte("body{}")},
+		"image.png": &fstest.MapFile{Data: []byte(compressText)},
+	}
+	h := NewHandler(fsys, WithDynamicCompression(DynamicCompression{Types: []string{" image/* "}, MinSize: 1}))
+	for path, encoding := range map[string]string{"/image.png": "gzip", "/style.css": "", "/small.css": ""} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if ce := w.Header().Get("Content-Encoding"); ce != encoding {
+			t.Errorf("%s: expected encoding %q, got %q", path, encoding, ce)
+		}
+	}
+}
+
+// TestDynamicCompression_FileCache tests the output is kept in the file cache
+func TestDynamicCompression_FileCache(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.Read(random)
+	fsys := &countingFS{MapFS: fstest.MapFS{
+		"style.css":  &fstest.MapFile{Data: []byte(compressText)},
+		"random.txt": &fstest.MapFile{Data: random},
+	}}
+	h := NewHandler(fsys, WithDynamicCompression(DynamicCompression{}), WithFileCache(1<<20, 1<<20))
+
+	var bodies []string
+	for _, path := range []string{"/style.css", "/style.css", "/random.txt", "/random.txt"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", path, w.Code)
+		}
+		bodies = append(bodies, w.Body.String())
+	}
+	if bodies[1] != bodies[0] {
+		t.Errorf("cached output differs")
+	}
+	// style.css once, random.txt on each request as its output is not kept
+	if fsys.opens.Load() != 3 {
+		t.Errorf("expected 3 opens, got %d", fsys.opens.Load())
+	}
+}
+
+// TestDynamicCompression_OwnCache tests the output is kept without the file cache
+func TestDynamicCompression_OwnCache(t *testing.T) {
+	fsys := &countingFS{MapFS: fstest.MapFS{
+		"style.css": &fstest.MapFile{Data: []byte(compressText)},
+	}}
+	h := NewHandler(fsys, WithDynamicCompression(DynamicCompression{}))
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/style.css", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("Range", "bytes=0-3")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusPartialContent || w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+		}
+	}
+	if got := fsys.opens.Load(); got != 1 {
+		t.Errorf("expected the file compressed once, got %d opens", got)
+	}
+}
+
+// TestDynamicCompression_WriteBack tests the output is stored next to a file of a directory root
+func TestDynamicCompression_WriteBack(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "style.css"), []byte(compressText), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := NewDirFS(dir, SymlinkFollow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(fsys, WithDynamicCompression(DynamicCompression{WriteBack: true}))
+	req := httptest.NewRequest("GET", "/style.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	body := w.Body.String()
+
+	orig, err := os.Stat(filepath.Join(dir, "style.css"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := os.Stat(filepath.Join(dir, "style.css.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gz.Size() != int64(len(body)) || !gz.ModTime().Equal(orig.ModTime()) {
+		t.Errorf("unexpected written file size=%d mtime=%s", gz.Size(), gz.ModTime())
+	}
+	// served as a pre-compressed file from now on
+	os.WriteFile(filepath.Join(dir, "style.css.gz"), []byte("replaced"), 0o644)
+	os.Chtimes(filepath.Join(dir, "style.css.gz"), orig.ModTime(), orig.ModTime())
+	req = httptest.NewRequest("GET", "/style.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Body.String(); got != "replaced" {
+		t.Errorf("expected the written file to be served, got %q", got)
+	}
+}
+
+// TestDynamicCompression_Conditional tests 304 responses do not compress
+func TestDynamicCompression_Conditional(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.Read(random)
+	fsys := &countingFS{MapFS: fstest.MapFS{
+		"style.css":  &fstest.MapFile{Data: []byte(compressText)},
+		"random.txt": &fstest.MapFile{Data: random},
+	}}
+	h := NewHandler(fsys, WithDynamicCompression(DynamicCompression{}))
+	req := httptest.NewRequest("GET", "/style.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	etag := w.Header().Get("ETag")
+
+	opens := fsys.opens.Load()
+	req = httptest.NewRequest("GET", "/style.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected status %d, got %d", http.StatusNotModified, w.Code)
+	}
+
+	if got := fsys.opens.Load() - opens; got != 0 {
+		t.Errorf("expected no open, got %d", got)
+	}
+
+	// incompressible: the original is sent with its own validators
+	req = httptest.NewRequest("GET", "/random.txt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "" || strings.HasSuffix(w.Header().Get("ETag"), "-gzip\"") {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+}
+
+// TestDynamicCompression_Head tests HEAD sends the headers of GET
+func TestDynamicCompression_Head(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.Read(random)
+	fsys := fstest.MapFS{
+		"style.css":  &fstest.MapFile{Data: []byte(compressText)},
+		"random.txt": &fstest.MapFile{Data: random},
+	}
+	h := NewHandler(fsys, WithDynamicCompression(DynamicCompression{}))
+	for _, path := range []string{"/style.css", "/random.txt"} {
+		var headers [2]http.Header
+		for i, method := range []string{"HEAD", "GET"} {
+			req := httptest.NewRequest(method, path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s %s: unexpected status %d", method, path, w.Code)
+			}
+			headers[i] = w.Header()
+		}
+		for _, key := range []string{"Content-Encoding", "Content-Length", "ETag", "Last-Modified", "Accept-Ranges"} {
+			if headers[0].Get(key) != headers[1].Get(key) {
+				t.Errorf("%s %s: HEAD %q, GET %q", path, key, headers[0].Get(key), headers[1].Get(key))
+			}
+		}
+	}
+}
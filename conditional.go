@@ -0,0 +1,127 @@
+package anystatic
+
+import (
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// makeETag returns a strong entity tag for one representation.
+// Each encoded variant gets its own tag so caches never mix them up.
+// A content hash, when the file info has one, replaces modification time and size.
+func makeETag(info fs.FileInfo, encoding string) string {
+	tag := "\"" + strconv.FormatInt(info.ModTime().Unix(), 16) + "-" + strconv.FormatInt(info.Size(), 16)
+	if ch, ok := info.(contentHasher); ok && ch.ContentHash() != "" {
+		tag = "\"" + ch.ContentHash()
+	}
+	if encoding != "" {
+		tag += "-" + encoding
+	}
+	return tag + "\""
+}
+
+// scanETag splits the first entity-tag off s.
+// It returns the opaque tag (with quotes), whether it is weak, and the rest of s.
+func scanETag(s string) (etag string, weak bool, rest string) {
+	s = strings.TrimLeft(s, " \t")
+	if strings.HasPrefix(s, "W/") {
+		weak = true
+		s = s[2:]
+	}
+	if len(s) < 2 || s[0] != '"' {
+		return "", false, ""
+	}
+	end := strings.IndexByte(s[1:], '"')
+	if end < 0 {
+		return "", false, ""
+	}
+	return s[:end+2], weak, s[end+2:]
+}
+
+// etagListMatch reports whether list (an If-Match / If-None-Match value) contains etag.
+// Strong comparison ignores weak tags in list.
+func etagListMatch(list string, etag string, strong bool) bool {
+	list = strings.TrimSpace(list)
+	if list == "*" {
+		return true
+	}
+	for list != "" {
+		tag, weak, rest := scanETag(list)
+		if tag == "" {
+			return false
+		}
+		if tag == strings.TrimPrefix(etag, "W/") && !(strong && weak) {
+			return true
+		}
+		list = strings.TrimLeft(rest, " \t")
+		if !strings.HasPrefix(list, ",") {
+			break
+		}
+		list = list[1:]
+	}
+	return false
+}
+
+// checkPreconditions evaluates the conditional request headers in RFC 9110 13.2.2 order.
+// It returns http.StatusOK when the request should proceed,
+// http.StatusNotModified or http.StatusPreconditionFailed otherwise.
+// A zero modtime (e.g. embed.FS) means unknown, and date conditions are ignored.
+func checkPreconditions(req *http.Request, etag string, modtime time.Time) int {
+	hasModTime := !modtime.IsZero()
+	modtime = modtime.Truncate(time.Second)
+	isGetHead := req.Method == http.MethodGet || req.Method == http.MethodHead
+	if im := req.Header.Get("If-Match"); im != "" {
+		if !etagListMatch(im, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := req.Header.Get("If-Unmodified-Since"); ius != "" && hasModTime {
+		if t, err := http.ParseTime(ius); err == nil && modtime.After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		if etagListMatch(inm, etag, false) {
+			if isGetHead {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims := req.Header.Get("If-Modified-Since"); ims != "" && isGetHead && hasModTime {
+		if t, err := http.ParseTime(ims); err == nil && !modtime.After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return http.StatusOK
+}
+
+// writeNotModified sends a 304 response without representation headers.
+func writeNotModified(res http.ResponseWriter) {
+	hdr := res.Header()
+	hdr.Del("Content-Type")
+	hdr.Del("Content-Length")
+	hdr.Del("Content-Encoding")
+	res.WriteHeader(http.StatusNotModified)
+}
+
+// writeValidators sets ETag and Last-Modified of a 200 response and evaluates the preconditions.
+// done is true when the response (304 or 412) has been written with code.
+func writeValidators(res http.ResponseWriter, req *http.Request, etag string, modTime time.Time) (code int, done bool) {
+	res.Header().Set("ETag", etag)
+	if !modTime.IsZero() {
+		res.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	switch code := checkPreconditions(req, etag, modTime); code {
+	case http.StatusNotModified:
+		writeNotModified(res)
+		return code, true
+	case http.StatusPreconditionFailed:
+		res.Header().Del("Content-Type")
+		res.Header().Del("Content-Encoding")
+		res.WriteHeader(code)
+		return code, true
+	}
+	res.Header().Set("Accept-Ranges", "bytes")
+	return http.StatusOK, false
+}
@@ -0,0 +1,226 @@
+package anystatic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// TestServeHTTP_ValidatorHeaders tests ETag and Last-Modified are emitted
+func TestServeHTTP_ValidatorHeaders(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"test.txt": &fstest.MapFile{Data: []byte("original content here"), ModTime: modTime},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/test.txt", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if lm := w.Header().Get("Last-Modified"); lm != "Tue, 02 Jan 2024 03:04:05 GMT" {
+		t.Errorf("unexpected Last-Modified: %q", lm)
+	}
+	if etag := w.Header().Get("ETag"); etag == "" {
+		t.Errorf("expected ETag header, got empty")
+	}
+}
+
+// TestServeHTTP_ETagPerEncoding tests each representation has its own ETag
+func TestServeHTTP_ETagPerEncoding(t *testing.T) {
+	fsys := fstest.MapFS{
+		"test.txt":    &fstest.MapFile{Data: []byte("original content here")},
+		"test.txt.gz": &fstest.MapFile{Data: []byte("gz content")},
+		"test.txt.br": &fstest.MapFile{Data: []byte("br")},
+	}
+	h := NewHandler(fsys)
+
+	seen := map[string]string{}
+	for _, ae := range []string{"", "gzip", "br"} {
+		req := httptest.NewRequest("GET", "/test.txt", nil)
+		if ae != "" {
+			req.Header.Set("Accept-Encoding", ae)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		etag := w.Header().Get("ETag")
+		if prev, ok := seen[etag]; ok {
+			t.Errorf("ETag %q shared by %q and %q", etag, prev, ae)
+		}
+		seen[etag] = ae
+	}
+}
+
+// TestServeHTTP_IfNoneMatch tests 304 on matching ETag
+func TestServeHTTP_IfNoneMatch(t *testing.T) {
+	fsys := fstest.MapFS{
+		"test.txt":    &fstest.MapFile{Data: []byte("original content here")},
+		"test.txt.gz": &fstest.MapFile{Data: []byte("gz content")},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/test.txt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	etag := w.Header().Get("ETag")
+
+	req = httptest.NewRequest("GET", "/test.txt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", `"other", W/`+etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected status %d, got %d", http.StatusNotModified, w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("expected empty body, got %q", w.Body.String())
+	}
+	if w.Header().Get("ETag") != etag {
+		t.Errorf("expected ETag %q on 304, got %q", etag, w.Header().Get("ETag"))
+	}
+	if cl := w.Header().Get("Content-Length"); cl != "" {
+		t.Errorf("expected no Content-Length on 304, got %q", cl)
+	}
+
+	// identity representation must not match the gzip ETag
+	req = httptest.NewRequest("GET", "/test.txt", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+// TestServeHTTP_IfModifiedSince tests 304 based on modification time
+func TestServeHTTP_IfModifiedSince(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"test.txt": &fstest.MapFile{Data: []byte("original content here"), ModTime: modTime},
+	}
+	h := NewHandler(fsys)
+
+	testCases := []struct {
+		name   string
+		since  time.Time
+		expect int
+	}{
+		{"same time", modTime, http.StatusNotModified},
+		{"later", modTime.Add(time.Hour), http.StatusNotModified},
+		{"earlier", modTime.Add(-time.Second), http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test.txt", nil)
+			req.Header.Set("If-Modified-Since", tc.since.Format(http.TimeFormat))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.expect {
+				t.Errorf("expected status %d, got %d", tc.expect, w.Code)
+			}
+		})
+	}
+}
+
+// TestServeHTTP_IfNoneMatchOverridesIfModifiedSince tests If-Modified-Since is ignored when If-None-Match is present
+func TestServeHTTP_IfNoneMatchOverridesIfModifiedSince(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"test.txt": &fstest.MapFile{Data: []byte("original content here"), ModTime: modTime},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/test.txt", nil)
+	req.Header.Set("If-None-Match", `"nomatch"`)
+	req.Header.Set("If-Modified-Since", modTime.Format(http.TimeFormat))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+// TestServeHTTP_IfMatch tests 412 when If-Match does not match
+func TestServeHTTP_IfMatch(t *testing.T) {
+	fsys := fstest.MapFS{
+		"test.txt": &fstest.MapFile{Data: []byte("original content here")},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/test.txt", nil)
+	req.Header.Set("If-Match", `"nomatch"`)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status %d, got %d", http.StatusPreconditionFailed, w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("expected empty body, got %q", w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/test.txt", nil)
+	req.Header.Set("If-Match", "*")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+// TestServeHTTP_IfUnmodifiedSince tests 412 when modified after the given date
+func TestServeHTTP_IfUnmodifiedSince(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"test.txt": &fstest.MapFile{Data: []byte("original content here"), ModTime: modTime},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/test.txt", nil)
+	req.Header.Set("If-Unmodified-Since", modTime.Add(-time.Hour).Format(http.TimeFormat))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status %d, got %d", http.StatusPreconditionFailed, w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/test.txt", nil)
+	req.Header.Set("If-Unmodified-Since", modTime.Format(http.TimeFormat))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+// TestETagListMatch tests weak and strong comparison of entity-tag lists
+func TestETagListMatch(t *testing.T) {
+	testCases := []struct {
+		list   string
+		etag   string
+		strong bool
+		expect bool
+	}{
+		{`"a"`, `"a"`, true, true},
+		{`W/"a"`, `"a"`, true, false},
+		{`W/"a"`, `"a"`, false, true},
+		{`"x", "a"`, `"a"`, true, true},
+		{`"x,y", "a"`, `"a"`, false, true},
+		{`"x"`, `"a"`, false, false},
+		{`*`, `"a"`, true, true},
+		{`garbage`, `"a"`, false, false},
+	}
+	for _, tc := range testCases {
+		if got := etagListMatch(tc.list, tc.etag, tc.strong); got != tc.expect {
+			t.Errorf("etagListMatch(%q, %q, %v) = %v, expected %v", tc.list, tc.etag, tc.strong, got, tc.expect)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package anystatic
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	pathpkg "path"
+	"regexp"
+	"strings"
+)
+
+// PathRule matches request paths for WithDeny and WithAllow.
+// A glob without "/" matches any path element (e.g. "*.bak"), a glob with "/" matches the whole path
+// (e.g. "private/*"), and a regexp matches the URL path with its leading slash.
+type PathRule struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// ParsePathRule parses a glob, or a regexp when prefixed with "~" (as nginx location).
+func ParsePathRule(spec string) (PathRule, error) {
+	spec = strings.TrimSpace(spec)
+	if re, ok := strings.CutPrefix(spec, "~"); ok {
+		r, err := regexp.Compile(strings.TrimSpace(re))
+		if err != nil {
+			return PathRule{}, fmt.Errorf("invalid regexp %q: %w", spec, err)
+		}
+		return PathRule{re: r}, nil
+	}
+	glob := strings.TrimPrefix(spec, "/")
+	if glob == "" {
+		return PathRule{}, fmt.Errorf("empty path rule")
+	}
+	if _, err := pathpkg.Match(glob, ""); err != nil {
+		return PathRule{}, fmt.Errorf("invalid glob %q: %w", spec, err)
+	}
+	return PathRule{glob: glob}, nil
+}
+
+// ParsePathRules parses a list of ParsePathRule specs.
+func ParsePathRules(specs []string) ([]PathRule, error) {
+	res := make([]PathRule, 0, len(specs))
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		rule, err := ParsePathRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rule)
+	}
+	return res, nil
+}
+
+func (r PathRule) match(path string) bool {
+	if r.re != nil {
+		return r.re.MatchString("/" + path)
+	}
+	if strings.Contains(r.glob, "/") {
+		ok, _ := pathpkg.Match(r.glob, strings.TrimSuffix(path, "/"))
+		return ok
+	}
+	for _, elem := range strings.Split(path, "/") {
+		if ok, _ := pathpkg.Match(r.glob, elem); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WithDenyDotfiles blocks paths with an element starting with "." (default true).
+// ".well-known" is never treated as a dotfile.
+func WithDenyDotfiles(enabled bool) HandlerOption {
+	return func(h *Handler) {
+		h.denyDotfiles = enabled
+	}
+}
+
+// WithDeny blocks paths matching any of rules.
+func WithDeny(rules ...PathRule) HandlerOption {
+	return func(h *Handler) {
+		h.denyRules = append(h.denyRules, rules...)
+	}
+}
+
+// WithAllow permits paths matching any of rules, even if blocked by WithDeny or WithDenyDotfiles.
+func WithAllow(rules ...PathRule) HandlerOption {
+	return func(h *Handler) {
+		h.allowRules = append(h.allowRules, rules...)
+	}
+}
+
+// CheckDenyStatus returns an error unless code is a status for blocked paths, 404 or 403.
+func CheckDenyStatus(code int) error {
+	if code != http.StatusNotFound && code != http.StatusForbidden {
+		return fmt.Errorf("invalid deny status %d, expected 404 or 403", code)
+	}
+	return nil
+}
+
+// WithDenyStatus sets the status for blocked paths, 404 (default) or 403.
+// Other codes are ignored, see CheckDenyStatus.
+func WithDenyStatus(code int) HandlerOption {
+	return func(h *Handler) {
+		if err := CheckDenyStatus(code); err != nil {
+			slog.Warn("deny status ignored", "error", err)
+			return
+		}
+		h.denyStatus = code
+	}
+}
+
+func hasDotElement(path string) bool {
+	for _, elem := range strings.Split(path, "/") {
+		if strings.HasPrefix(elem, ".") && elem != ".well-known" {
+			return true
+		}
+	}
+	return false
+}
+
+// denied reports whether path (without the leading slash) is blocked by the deny policy.
+func (h *Handler) denied(path string) bool {
+	if !h.denyDotfiles && len(h.denyRules) == 0 {
+		return false
+	}
+	for _, rule := range h.allowRules {
+		if rule.match(path) {
+			return false
+		}
+	}
+	if h.denyDotfiles && hasDotElement(path) {
+		return true
+	}
+	for _, rule := range h.denyRules {
+		if rule.match(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package anystatic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+// TestParsePathRule tests glob and regexp rule parsing
+func TestParsePathRule(t *testing.T) {
+	testCases := []struct {
+		spec   string
+		path   string
+		expect bool
+	}{
+		{"*.bak", "public/index.html.bak", true},
+		{"*.bak", "public/index.html", false},
+		{"/private/*", "private/data.txt", true},
+		{"private/*", "public/private/data.txt", false},
+		{`~\.(bak|swp)$`, "a/b.swp", true},
+		{`~^/private/`, "private/x", true},
+		{`~^/private/`, "public/private/x", false},
+	}
+	for _, tc := range testCases {
+		rule, err := ParsePathRule(tc.spec)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tc.spec, err)
+			continue
+		}
+		if got := rule.match(tc.path); got != tc.expect {
+			t.Errorf("%q match %q: expected %v, got %v", tc.spec, tc.path, tc.expect, got)
+		}
+	}
+
+	for _, spec := range []string{"", "[", "~("} {
+		if _, err := ParsePathRule(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+// TestServeHTTP_DenyDotfiles tests dotfiles are blocked by default
+func TestServeHTTP_DenyDotfiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		".env":                 &fstest.MapFile{Data: []byte("SECRET=1")},
+		".git/config":          &fstest.MapFile{Data: []byte("[core]")},
+		".well-known/security": &fstest.MapFile{Data: []byte("contact")},
+		"public/.htpasswd":     &fstest.MapFile{Data: []byte("user:pass")},
+		"public/index.html":    &fstest.MapFile{Data: []byte("index")},
+	}
+	h := NewHandler(fsys)
+
+	testCases := []struct {
+		path   string
+		status int
+	}{
+		{"/.env", http.StatusNotFound},
+		{"/.git/config", http.StatusNotFound},
+		{"/public/.htpasswd", http.StatusNotFound},
+		{"/.well-known/security", http.StatusOK},
+		{"/public/index.html", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, w.Code)
+		}
+	}
+
+	h = NewHandler(fsys, WithDenyDotfiles(false))
+	req := httptest.NewRequest("GET", "/.env", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d with dotfiles allowed, got %d", http.StatusOK, w.Code)
+	}
+}
+
+// TestServeHTTP_DenyRules tests deny and allow lists and the deny status
+func TestServeHTTP_DenyRules(t *testing.T) {
+	deny, err := ParsePathRules([]string{"*.bak", "*~", "/private/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow, err := ParsePathRules([]string{`~^/private/ok\.txt$`, ".env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		".env":                  &fstest.MapFile{Data: []byte("SECRET=1")},
+		".git/config":           &fstest.MapFile{Data: []byte("[core]")},
+		"public/index.html":     &fstest.MapFile{Data: []byte("index")},
+		"public/index.html.bak": &fstest.MapFile{Data: []byte("backup")},
+		"public/notes.txt~":     &fstest.MapFile{Data: []byte("editor")},
+		"private/data.txt":      &fstest.MapFile{Data: []byte("private")},
+		"private/ok.txt":        &fstest.MapFile{Data: []byte("ok")},
+	}
+	h := NewHandler(fsys, WithDeny(deny...), WithAllow(allow...), WithDenyStatus(http.StatusForbidden))
+
+	testCases := []struct {
+		path   string
+		status int
+	}{
+		{"/public/index.html.bak", http.StatusForbidden},
+		{"/public/notes.txt~", http.StatusForbidden},
+		{"/private/data.txt", http.StatusForbidden},
+		{"/private/ok.txt", http.StatusOK},
+		{"/.env", http.StatusOK},
+		{"/.git/config", http.StatusForbidden},
+		{"/public/", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, w.Code)
+		}
+	}
+}
+
+// TestServeHTTP_DenyResolved tests try_files candidates are checked as well
+func TestServeHTTP_DenyResolved(t *testing.T) {
+	deny, _ := ParsePathRules([]string{"*.bak"})
+	fsys := fstest.MapFS{
+		"public/index.html":     &fstest.MapFile{Data: []byte("index")},
+		"public/index.html.bak": &fstest.MapFile{Data: []byte("backup")},
+	}
+	h := NewHandler(fsys, WithDeny(deny...), WithTryFiles("$uri", "$uri.bak"))
+
+	req := httptest.NewRequest("GET", "/public/index.html", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	delete(fsys, "public/index.html")
+	h = NewHandler(fsys, WithDeny(deny...), WithTryFiles("$uri", "$uri.bak"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// TestServeHTTP_DenyAutoIndex tests blocked entries are hidden from listings
+func TestServeHTTP_DenyAutoIndex(t *testing.T) {
+	fsys := fstest.MapFS{
+		".env":              &fstest.MapFile{Data: []byte("SECRET=1")},
+		".git/config":       &fstest.MapFile{Data: []byte("[core]")},
+		"public/index.html": &fstest.MapFile{Data: []byte("index")},
+	}
+	h := NewHandler(fsys, WithAutoIndex(true))
+
+	entries, err := h.readDirIndex("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ent := range entries {
+		if ent.Name == ".env" || ent.Name == ".git" {
+			t.Errorf("unexpected %q in listing", ent.Name)
+		}
+	}
+}
+
+// TestWithDenyStatus_Invalid tests a status other than 404 and 403 is refused
+func TestWithDenyStatus_Invalid(t *testing.T) {
+	fsys := fstest.MapFS{
+		".env": &fstest.MapFile{Data: []byte("SECRET=1")},
+	}
+	for _, code := range []int{http.StatusOK, http.StatusFound, http.StatusInternalServerError} {
+		if err := CheckDenyStatus(code); err == nil {
+			t.Errorf("%d: expected error", code)
+		}
+		h := NewHandler(fsys, WithDenyStatus(code))
+		req := httptest.NewRequest("GET", "/.env", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%d: expected status %d, got %d", code, http.StatusNotFound, w.Code)
+		}
+		config := &Config{RootDir: t.TempDir(), DenyStatus: code}
+		if _, err := New(context.Background(), http.NotFoundHandler(), config, "test"); err == nil {
+			t.Errorf("%d: expected error from New", code)
+		}
+	}
+	for _, code := range []int{http.StatusNotFound, http.StatusForbidden} {
+		if err := CheckDenyStatus(code); err != nil {
+			t.Errorf("%d: unexpected error %v", code, err)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package anystatic
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SymlinkPolicy decides how DirFS treats symbolic links in the served tree.
+type SymlinkPolicy int
+
+const (
+	// SymlinkFollow follows every symlink, like os.DirFS.
+	SymlinkFollow SymlinkPolicy = iota
+	// SymlinkDeny refuses any path that goes through a symlink.
+	SymlinkDeny
+	// SymlinkWithinRoot follows symlinks whose resolved real path stays inside the root.
+	SymlinkWithinRoot
+)
+
+func (p SymlinkPolicy) String() string {
+	switch p {
+	case SymlinkDeny:
+		return "deny"
+	case SymlinkWithinRoot:
+		return "within-root"
+	}
+	return "follow"
+}
+
+// ParseSymlinkPolicy parses "follow", "deny" or "within-root".
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "follow":
+		return SymlinkFollow, nil
+	case "deny":
+		return SymlinkDeny, nil
+	case "within-root", "withinroot":
+		return SymlinkWithinRoot, nil
+	}
+	return SymlinkFollow, fmt.Errorf("invalid symlink policy %q, expected follow, deny or within-root", s)
+}
+
+// DirFS serves an OS directory like os.DirFS, with a symlink policy.
+// Open returns the *os.File itself, so net/http can send it with sendfile/splice.
+type DirFS struct {
+	root     string
+	realRoot string
+	policy   SymlinkPolicy
+}
+
+// NewDirFS returns a filesystem for the directory root.
+// Symlinks in root itself are resolved once here and are not subject to policy.
+func NewDirFS(root string, policy SymlinkPolicy) (*DirFS, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	realRoot, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		// the root may appear later, e.g. a volume mounted after startup
+		slog.Warn("cannot resolve root", "root", abs, "error", err)
+		realRoot = abs
+	}
+	return &DirFS{root: abs, realRoot: realRoot, policy: policy}, nil
+}
+
+func (d *DirFS) String() string {
+	return d.root
+}
+
+// check applies the symlink policy to name, a valid fs path, and returns its real path,
+// or "" when there is nothing to check.
+func (d *DirFS) check(op, name string) (string, error) {
+	if d.policy == SymlinkFollow || name == "." {
+		return "", nil
+	}
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(d.root, filepath.FromSlash(name)))
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	switch d.policy {
+	case SymlinkDeny:
+		if resolved != filepath.Join(d.realRoot, filepath.FromSlash(name)) {
+			return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+		}
+	case SymlinkWithinRoot:
+		if resolved != d.realRoot && !strings.HasPrefix(resolved, d.realRoot+string(filepath.Separator)) {
+			return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+		}
+	}
+	return resolved, nil
+}
+
+// join returns the OS path of name, a valid fs path. Under a symlink policy it is the
+// real path that was checked, so a link swapped after the check is not followed.
+func (d *DirFS) join(op, name string) (string, error) {
+	if !fs.ValidPath(name) || (filepath.Separator != '/' && strings.ContainsAny(name, `\:`)) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	resolved, err := d.check(op, name)
+	if err != nil {
+		return "", err
+	}
+	if resolved != "" {
+		return resolved, nil
+	}
+	return filepath.Join(d.root, filepath.FromSlash(name)), nil
+}
+
+// relPathError reports err of an OS call with the fs path name instead of the OS path.
+func relPathError(op, name string, err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return &fs.PathError{Op: op, Path: name, Err: pe.Err}
+	}
+	return err
+}
+
+// OpenFile opens name and returns the underlying *os.File.
+func (d *DirFS) OpenFile(name string) (*os.File, error) {
+	fname, err := d.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := os.Open(fname)
+	if err != nil {
+		return nil, relPathError("open", name, err)
+	}
+	return fp, nil
+}
+
+func (d *DirFS) Open(name string) (fs.File, error) {
+	fp, err := d.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return fp, nil
+}
+
+func (d *DirFS) Stat(name string) (fs.FileInfo, error) {
+	fname, err := d.join("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fname)
+	if err != nil {
+		return nil, relPathError("stat", name, err)
+	}
+	if base := pathpkg.Base(name); info.Name() != base && name != "." {
+		// named after the link, as os.Stat does
+		return &renamedInfo{FileInfo: info, name: base}, nil
+	}
+	return info, nil
+}
+
+// renamedInfo is the file info of a link target under the name of the link.
+type renamedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i *renamedInfo) Name() string { return i.name }
+
+// WriteFile creates name with data and modification time modTime, through a temporary
+// file renamed into place. It fails with fs.ErrExist if name exists.
+func (d *DirFS) WriteFile(name string, data []byte, modTime time.Time) error {
+	if !fs.ValidPath(name) || name == "." || (filepath.Separator != '/' && strings.ContainsAny(name, `\:`)) {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	// the directory is checked, as name does not exist yet
+	dir, err := d.join("write", pathpkg.Dir(name))
+	if err != nil {
+		return err
+	}
+	fname := filepath.Join(dir, pathpkg.Base(name))
+	if _, err := os.Lstat(fname); err == nil {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fname), "."+filepath.Base(fname)+".*")
+	if err != nil {
+		return relPathError("write", name, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return relPathError("write", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return relPathError("write", name, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return relPathError("write", name, err)
+	}
+	if err := os.Chtimes(tmp.Name(), modTime, modTime); err != nil {
+		return relPathError("write", name, err)
+	}
+	if err := os.Rename(tmp.Name(), fname); err != nil {
+		return relPathError("write", name, err)
+	}
+	return nil
+}
+
+// ReadDir hides entries refused by the symlink policy.
+func (d *DirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fname, err := d.join("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	ents, err := os.ReadDir(fname)
+	if err != nil {
+		return nil, relPathError("readdir", name, err)
+	}
+	if d.policy == SymlinkFollow {
+		return ents, nil
+	}
+	res := ents[:0]
+	for _, ent := range ents {
+		if ent.Type()&fs.ModeSymlink != 0 {
+			if _, err := d.check("readdir", pathJoin(name, ent.Name())); err != nil {
+				continue
+			}
+		}
+		res = append(res, ent)
+	}
+	return res, nil
+}
+
+func pathJoin(dir, name string) string {
+	if dir == "." {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// findSymlinks walks root and returns the number of symlinks and up to limit of their paths.
+func findSymlinks(root string, limit int) (int, []string, error) {
+	count := 0
+	var found []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			count++
+			if len(found) < limit {
+				found = append(found, path)
+			}
+		}
+		return nil
+	})
+	return count, found, err
+}
+
+// WarnSymlinks logs a startup warning when root contains symlinks.
+func WarnSymlinks(root string, policy SymlinkPolicy) {
+	count, found, err := findSymlinks(root, 10)
+	if err != nil {
+		slog.Warn("symlink scan failed", "root", root, "error", err)
+		return
+	}
+	if count != 0 {
+		slog.Warn("symlinks found in root", "root", root, "count", count, "examples", found, "policy", policy.String())
+	}
+}
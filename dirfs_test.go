@@ -0,0 +1,231 @@
+package anystatic
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// symlinkTree creates root/{file.txt, inner -> file.txt, sub/, subLink -> sub, outer -> ../secret.txt}
+func symlinkTree(t *testing.T) string {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	for _, dir := range []string{root, filepath.Join(root, "sub")} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(base, "secret.txt"):      "secret",
+		filepath.Join(root, "file.txt"):        "file",
+		filepath.Join(root, "sub", "data.txt"): "data",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"inner":   "file.txt",
+		"subLink": "sub",
+		"outer":   filepath.Join("..", "secret.txt"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("symlink not supported: %v", err)
+		}
+	}
+	return root
+}
+
+// TestParseSymlinkPolicy tests policy parsing
+func TestParseSymlinkPolicy(t *testing.T) {
+	for spec, expect := range map[string]SymlinkPolicy{"": SymlinkFollow, "follow": SymlinkFollow, "DENY": SymlinkDeny, "within-root": SymlinkWithinRoot} {
+		got, err := ParseSymlinkPolicy(spec)
+		if err != nil || got != expect {
+			t.Errorf("%q: expected %v, got %v (%v)", spec, expect, got, err)
+		}
+	}
+	if _, err := ParseSymlinkPolicy("sometimes"); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+// TestDirFS_SymlinkPolicy tests each policy against inner and outer links
+func TestDirFS_SymlinkPolicy(t *testing.T) {
+	root := symlinkTree(t)
+
+	testCases := []struct {
+		policy SymlinkPolicy
+		name   string
+		ok     bool
+	}{
+		{SymlinkFollow, "file.txt", true},
+		{SymlinkFollow, "inner", true},
+		{SymlinkFollow, "outer", true},
+		{SymlinkDeny, "file.txt", true},
+		{SymlinkDeny, "sub/data.txt", true},
+		{SymlinkDeny, "inner", false},
+		{SymlinkDeny, "subLink/data.txt", false},
+		{SymlinkDeny, "outer", false},
+		{SymlinkWithinRoot, "inner", true},
+		{SymlinkWithinRoot, "subLink/data.txt", true},
+		{SymlinkWithinRoot, "outer", false},
+	}
+	for _, tc := range testCases {
+		d, err := NewDirFS(root, tc.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, statErr := d.Stat(tc.name)
+		fp, openErr := d.Open(tc.name)
+		if openErr == nil {
+			fp.Close()
+		}
+		if (statErr == nil) != tc.ok || (openErr == nil) != tc.ok {
+			t.Errorf("%v %s: expected ok=%v, got stat %v, open %v", tc.policy, tc.name, tc.ok, statErr, openErr)
+		}
+		if !tc.ok && !errors.Is(statErr, fs.ErrPermission) {
+			t.Errorf("%v %s: expected permission error, got %v", tc.policy, tc.name, statErr)
+		}
+	}
+}
+
+// TestDirFS_ReadDir tests refused links are hidden from listings
+func TestDirFS_ReadDir(t *testing.T) {
+	root := symlinkTree(t)
+	d, err := NewDirFS(root, SymlinkWithinRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents, err := fs.ReadDir(d, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, ent := range ents {
+		names[ent.Name()] = true
+	}
+	for _, name := range []string{"file.txt", "inner", "sub", "subLink"} {
+		if !names[name] {
+			t.Errorf("expected %q in listing", name)
+		}
+	}
+	if names["outer"] {
+		t.Errorf("unexpected outer in listing")
+	}
+}
+
+// TestServeHTTP_DirFSOutsideRoot tests a link outside the root is not served
+func TestServeHTTP_DirFSOutsideRoot(t *testing.T) {
+	root := symlinkTree(t)
+	d, err := NewDirFS(root, SymlinkWithinRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(d)
+
+	req := httptest.NewRequest("GET", "/outer", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/inner", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if body := w.Body.String(); body != "file" {
+		t.Errorf("expected body 'file', got %q", body)
+	}
+}
+
+// TestFindSymlinks tests the startup scan
+func TestFindSymlinks(t *testing.T) {
+	root := symlinkTree(t)
+	count, found, err := findSymlinks(root, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || len(found) != 2 {
+		t.Errorf("expected 3 symlinks with 2 examples, got %d %v", count, found)
+	}
+}
+
+// TestDirFS_OSFile tests files are the *os.File itself, also through a union
+func TestDirFS_OSFile(t *testing.T) {
+	root := symlinkTree(t)
+	d, err := NewDirFS(root, SymlinkFollow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fsys := range []fs.FS{d, NewUnionFS(d)} {
+		fp, err := fsys.Open("file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := fp.(*os.File); !ok {
+			t.Errorf("expected *os.File, got %T", fp)
+		}
+		fp.Close()
+	}
+	if _, err := d.Open("../secret.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected invalid path error, got %v", err)
+	}
+	if _, err := d.Stat("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+// TestDirFS_OpenResolved tests a link is opened at the real path checked by the policy
+func TestDirFS_OpenResolved(t *testing.T) {
+	root := symlinkTree(t)
+	d, err := NewDirFS(root, SymlinkWithinRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := d.OpenFile("inner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	realRoot, _ := filepath.EvalSymlinks(root)
+	if fp.Name() != filepath.Join(realRoot, "file.txt") {
+		t.Errorf("expected the real path opened, got %s", fp.Name())
+	}
+	if info, err := d.Stat("inner"); err != nil || info.Name() != "inner" {
+		t.Errorf("expected the link name, got %v (%v)", info, err)
+	}
+
+	if err := d.WriteFile("subLink/new.txt", []byte("new"), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "sub", "new.txt")); err != nil || string(data) != "new" {
+		t.Errorf("expected the file written below the link target, got %q (%v)", data, err)
+	}
+	if err := d.WriteFile("outer/x.txt", []byte("x"), time.Now()); !errors.Is(err, fs.ErrPermission) && !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected refusal outside the root, got %v", err)
+	}
+}
+
+// TestCopyBody tests an *os.File is sent no longer than the stat size
+func TestCopyBody(t *testing.T) {
+	root := symlinkTree(t)
+	d, _ := NewDirFS(root, SymlinkFollow)
+	fp, err := d.Open("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	var buf bytes.Buffer
+	if n, err := copyBody(&buf, fp, 2); err != nil || n != 2 || buf.String() != "fi" {
+		t.Errorf("unexpected copy %d %q %v", n, buf.String(), err)
+	}
+}
@@ -0,0 +1,72 @@
+package anystatic
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// WithErrorPage serves document (e.g. "/404.html") as the body of responses with the given status codes.
+// The document is looked up in the same filesystem and negotiated like any other file.
+// If it is missing, a short plain-text body is sent instead.
+func WithErrorPage(document string, codes ...int) HandlerOption {
+	return func(h *Handler) {
+		if h.errorPages == nil {
+			h.errorPages = map[int]string{}
+		}
+		for _, code := range codes {
+			h.errorPages[code] = strings.TrimPrefix(document, "/")
+		}
+	}
+}
+
+// ParseErrorPage parses "code[,code...]=document", e.g. "500,502,503,504=/50x.html".
+func ParseErrorPage(spec string) ([]int, string, error) {
+	codesStr, document, ok := strings.Cut(spec, "=")
+	document = strings.TrimSpace(document)
+	if !ok || document == "" {
+		return nil, "", fmt.Errorf("invalid error page %q, expected code=document", spec)
+	}
+	var codes []int
+	for _, v := range strings.Split(codesStr, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || code < 400 || code > 599 {
+			return nil, "", fmt.Errorf("invalid status code %q in error page %q", v, spec)
+		}
+		codes = append(codes, code)
+	}
+	return codes, document, nil
+}
+
+// writeError answers with status code, using the error page when one is configured.
+func (h *Handler) writeError(res http.ResponseWriter, req *http.Request, code int) int {
+	document, ok := h.errorPages[code]
+	if !ok {
+		res.WriteHeader(code)
+		return code
+	}
+	for _, key := range []string{"Accept-Ranges", "Content-Encoding", "Content-Length", "Content-Type", "ETag", "Last-Modified"} {
+		res.Header().Del(key)
+	}
+	info, err := h.stat(document)
+	if err != nil || info.IsDir() {
+		slog.Warn("error page not found", "status", code, "document", document, "error", err)
+		return h.writeErrorText(res, code)
+	}
+	return h.serveFile(res, req, document, info, code)
+}
+
+// writeErrorText sends a plain-text body like "404 Not Found".
+func (h *Handler) writeErrorText(res http.ResponseWriter, code int) int {
+	body := strconv.Itoa(code) + " " + http.StatusText(code) + "\n"
+	hdr := res.Header()
+	hdr.Del("Content-Encoding")
+	hdr.Set("Content-Type", "text/plain; charset=utf-8")
+	hdr.Set("X-Content-Type-Options", "nosniff")
+	hdr.Set("Content-Length", strconv.Itoa(len(body)))
+	res.WriteHeader(code)
+	res.Write([]byte(body))
+	return code
+}
@@ -0,0 +1,125 @@
+package anystatic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+// TestParseErrorPage tests error page spec parsing
+func TestParseErrorPage(t *testing.T) {
+	codes, document, err := ParseErrorPage("500, 502,503=/50x.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if document != "/50x.html" {
+		t.Errorf("unexpected document: %q", document)
+	}
+	if len(codes) != 3 || codes[0] != 500 || codes[1] != 502 || codes[2] != 503 {
+		t.Errorf("unexpected codes: %v", codes)
+	}
+
+	for _, spec := range []string{"404", "404=", "abc=/x.html", "200=/x.html"} {
+		if _, _, err := ParseErrorPage(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+// TestServeHTTP_ErrorPage tests the 404 document is served with status 404
+func TestServeHTTP_ErrorPage(t *testing.T) {
+	fsys := fstest.MapFS{
+		"404.html": &fstest.MapFile{Data: []byte("<html>not found page</html>")},
+	}
+	h := NewHandler(fsys, WithErrorPage("/404.html", http.StatusNotFound))
+
+	req := httptest.NewRequest("GET", "/missing.txt", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if body := w.Body.String(); body != "<html>not found page</html>" {
+		t.Errorf("unexpected body: %q", body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("unexpected Content-Type: %q", ct)
+	}
+	if etag := w.Header().Get("ETag"); etag != "" {
+		t.Errorf("expected no ETag on error page, got %q", etag)
+	}
+}
+
+// TestServeHTTP_ErrorPageEncoded tests the error document is negotiated
+func TestServeHTTP_ErrorPageEncoded(t *testing.T) {
+	fsys := fstest.MapFS{
+		"404.html":    &fstest.MapFile{Data: []byte("<html>not found page</html>")},
+		"404.html.br": &fstest.MapFile{Data: []byte("br 404")},
+	}
+	h := NewHandler(fsys, WithErrorPage("/404.html", http.StatusNotFound))
+
+	req := httptest.NewRequest("GET", "/missing.txt", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	req.Header.Set("If-None-Match", "*")
+	req.Header.Set("Range", "bytes=0-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if enc := w.Header().Get("Content-Encoding"); enc != "br" {
+		t.Errorf("expected Content-Encoding: br, got %q", enc)
+	}
+	if body := w.Body.String(); body != "br 404" {
+		t.Errorf("unexpected body: %q", body)
+	}
+}
+
+// TestServeHTTP_ErrorPageMissing tests the plain-text fallback body
+func TestServeHTTP_ErrorPageMissing(t *testing.T) {
+	fsys := fstest.MapFS{
+		"ok.txt": &fstest.MapFile{Data: []byte("ok")},
+	}
+	h := NewHandler(fsys, WithErrorPage("/50x.html", http.StatusInternalServerError, http.StatusMethodNotAllowed))
+
+	req := httptest.NewRequest("DELETE", "/ok.txt", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+	if body := w.Body.String(); body != "405 Method Not Allowed\n" {
+		t.Errorf("unexpected body: %q", body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected Content-Type: %q", ct)
+	}
+	if allow := w.Header().Get("Allow"); allow == "" {
+		t.Errorf("expected Allow header to be kept")
+	}
+}
+
+// TestServeHTTP_ErrorPageNotAcceptable tests the error document ignores identity;q=0
+func TestServeHTTP_ErrorPageNotAcceptable(t *testing.T) {
+	fsys := fstest.MapFS{
+		"404.html": &fstest.MapFile{Data: []byte("<html>not found page</html>")},
+		"ok.txt":   &fstest.MapFile{Data: []byte("ok")},
+	}
+	h := NewHandler(fsys, WithErrorPage("/404.html", http.StatusNotFound, http.StatusNotAcceptable))
+
+	req := httptest.NewRequest("GET", "/ok.txt", nil)
+	req.Header.Set("Accept-Encoding", "identity;q=0")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("expected status %d, got %d", http.StatusNotAcceptable, w.Code)
+	}
+	if body := w.Body.String(); body != "<html>not found page</html>" {
+		t.Errorf("unexpected body: %q", body)
+	}
+}
@@ -0,0 +1,160 @@
+package anystatic
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/fs"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// defaultFileCacheMetaEntries is the metadata cache size set up by WithFileCache when none is configured.
+const defaultFileCacheMetaEntries = 4096
+
+// defaultFileCacheMaxFile is the largest cached file when the plugin configuration sets none.
+const defaultFileCacheMaxFile = 1 << 20
+
+// fileCacheLogInterval is the least time between two logs of the file cache counters.
+const fileCacheLogInterval = time.Minute
+
+// WithFileCache keeps the bytes of files up to maxFileSize in memory, up to maxBytes in total,
+// dropping the least recently used files first. The original and each pre-compressed variant
+// are cached separately. An entry is used while the modification time and size of the file are
+// unchanged; those come from the metadata cache (see WithMetaCache, enabled with defaults when
+// not set), so hits need no filesystem call and changes are seen after its TTL.
+// Hit and miss counters are logged once a minute and returned by FileCacheStats.
+func WithFileCache(maxBytes, maxFileSize int64) HandlerOption {
+	return func(h *Handler) {
+		if maxBytes <= 0 || maxFileSize <= 0 {
+			h.fileCache = nil
+			return
+		}
+		h.fileCache = newFileCache(maxBytes, maxFileSize)
+	}
+}
+
+func newFileCache(maxBytes, maxFileSize int64) *fileCache {
+	if maxFileSize > maxBytes {
+		maxFileSize = maxBytes
+	}
+	return &fileCache{maxBytes: maxBytes, maxFileSize: maxFileSize, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+type cachedFile struct {
+	name    string
+	modTime time.Time
+	size    int64
+	data    []byte
+}
+
+type fileCache struct {
+	mu          sync.Mutex
+	maxBytes    int64
+	maxFileSize int64
+	bytes       int64
+	entries     map[string]*list.Element
+	lru         *list.List // front is the most recently used *cachedFile
+
+	hits, misses uint64
+	lastLog      time.Time
+}
+
+// FileCacheStats returns the hit and miss counts of the file cache, and the bytes it holds.
+func (h *Handler) FileCacheStats() (hits, misses uint64, size int64) {
+	if h.fileCache == nil {
+		return 0, 0, 0
+	}
+	c := h.fileCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses, c.bytes
+}
+
+// get returns the cached bytes of name when they match info.
+func (c *fileCache) get(name string, info fs.FileInfo) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.logStats(time.Now())
+	elem, ok := c.entries[name]
+	if ok {
+		ent := elem.Value.(*cachedFile)
+		if ent.modTime.Equal(info.ModTime()) && ent.size == info.Size() {
+			c.hits++
+			c.lru.MoveToFront(elem)
+			return ent.data, true
+		}
+		c.removeElem(elem)
+	}
+	c.misses++
+	return nil, false
+}
+
+func (c *fileCache) put(name string, info fs.FileInfo, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[name]; ok {
+		c.removeElem(elem)
+	}
+	c.entries[name] = c.lru.PushFront(&cachedFile{name: name, modTime: info.ModTime(), size: info.Size(), data: data})
+	c.bytes += int64(len(data))
+	for c.bytes > c.maxBytes {
+		c.removeElem(c.lru.Back())
+	}
+}
+
+func (c *fileCache) removeElem(elem *list.Element) {
+	ent := c.lru.Remove(elem).(*cachedFile)
+	delete(c.entries, ent.name)
+	c.bytes -= int64(len(ent.data))
+}
+
+// logStats logs the counters at most once per fileCacheLogInterval; c.mu is held.
+func (c *fileCache) logStats(now time.Time) {
+	if now.Sub(c.lastLog) < fileCacheLogInterval {
+		return
+	}
+	if !c.lastLog.IsZero() {
+		slog.Info("file cache", "hits", c.hits, "misses", c.misses, "entries", len(c.entries), "bytes", c.bytes)
+	}
+	c.lastLog = now
+}
+
+// memFile is a cached file served from memory.
+type memFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+// openCached opens name, whose file info is info, from the file cache, filling it on a miss.
+func (h *Handler) openCached(vfs fs.StatFS, name string, info fs.FileInfo) (fs.File, error) {
+	if h.fileCache == nil || info.Size() > h.fileCache.maxFileSize {
+		return vfs.Open(name)
+	}
+	if data, ok := h.fileCache.get(name, info); ok {
+		return &memFile{Reader: bytes.NewReader(data), info: info}, nil
+	}
+	fp, err := vfs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	data := make([]byte, info.Size())
+	n, err := io.ReadFull(fp, data)
+	if err != nil {
+		// shrunk since stat
+		slog.Debug("file changed while caching", "path", name, "error", err)
+		return vfs.Open(name)
+	}
+	if extra, _ := fp.Read(make([]byte, 1)); extra != 0 {
+		// grew since stat: serve this time, cache once the new size is known
+		slog.Debug("file changed while caching", "path", name)
+		return &memFile{Reader: bytes.NewReader(data[:n]), info: info}, nil
+	}
+	h.fileCache.put(name, info, data)
+	return &memFile{Reader: bytes.NewReader(data), info: info}, nil
+}
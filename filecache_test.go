@@ -0,0 +1,149 @@
+package anystatic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// TestFileCache_Hit tests a cached file is served without filesystem calls
+func TestFileCache_Hit(t *testing.T) {
+	fsys := &countingFS{MapFS: fstest.MapFS{
+		"data": &fstest.MapFile{Data: []byte("<html>data</html>")},
+	}}
+	h := NewHandler(fsys, WithFileCache(1024, 1024))
+
+	req := httptest.NewRequest("GET", "/data", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	stats, opens := fsys.stats.Load(), fsys.opens.Load()
+
+	req = httptest.NewRequest("GET", "/data", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Body.String() != "<html>data</html>" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+	if fsys.stats.Load() != stats || fsys.opens.Load() != opens {
+		t.Errorf("expected no filesystem call on hit, got %d stats and %d opens", fsys.stats.Load()-stats, fsys.opens.Load()-opens)
+	}
+	hits, misses, size := h.FileCacheStats()
+	if hits != 1 || misses != 1 || size != int64(len("<html>data</html>")) {
+		t.Errorf("unexpected stats hits=%d misses=%d size=%d", hits, misses, size)
+	}
+
+	req = httptest.NewRequest("GET", "/data", nil)
+	req.Header.Set("Range", "bytes=6-9")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "data" {
+		t.Errorf("unexpected range response %d %q", w.Code, w.Body.String())
+	}
+}
+
+// TestFileCache_Variant tests the original and a pre-compressed variant are cached separately
+func TestFileCache_Variant(t *testing.T) {
+	fsys := &countingFS{MapFS: fstest.MapFS{
+		"data":    &fstest.MapFile{Data: []byte("<html>data</html>")},
+		"data.gz": &fstest.MapFile{Data: []byte("gz")},
+	}}
+	h := NewHandler(fsys, WithFileCache(1024, 1024))
+
+	testCases := []struct {
+		accept   string
+		encoding string
+		body     string
+	}{
+		{"gzip", "gzip", "gz"},
+		{"", "", "<html>data</html>"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/data", nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept-Encoding", tc.accept)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	opens := fsys.opens.Load()
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/data", nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept-Encoding", tc.accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Body.String() != tc.body || w.Header().Get("Content-Encoding") != tc.encoding {
+			t.Errorf("%q: unexpected response %q", tc.accept, w.Body.String())
+		}
+	}
+	if fsys.opens.Load() != opens {
+		t.Errorf("expected both representations cached, got %d opens", fsys.opens.Load()-opens)
+	}
+	if _, _, size := h.FileCacheStats(); size != int64(len("gz")+len("<html>data</html>")) {
+		t.Errorf("unexpected cache size %d", size)
+	}
+}
+
+// TestFileCache_Revalidate tests a changed file is read again
+func TestFileCache_Revalidate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data": &fstest.MapFile{Data: []byte("<html>data</html>")},
+	}
+	h := NewHandler(fsys, WithMetaCache(10, time.Nanosecond), WithFileCache(1024, 1024))
+	req := httptest.NewRequest("GET", "/data", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	fsys["data"] = &fstest.MapFile{Data: []byte("<html>new</html>"), ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	time.Sleep(time.Millisecond)
+	req = httptest.NewRequest("GET", "/data", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Body.String() != "<html>new</html>" {
+		t.Errorf("expected new content, got %q", w.Body.String())
+	}
+	if hits, misses, _ := h.FileCacheStats(); hits != 0 || misses != 2 {
+		t.Errorf("unexpected stats hits=%d misses=%d", hits, misses)
+	}
+}
+
+// TestFileCache_Limit tests the byte limit, the file size limit and LRU eviction
+func TestFileCache_Limit(t *testing.T) {
+	fsys := &countingFS{MapFS: fstest.MapFS{
+		"a":   &fstest.MapFile{Data: []byte("aaaa")},
+		"b":   &fstest.MapFile{Data: []byte("bbbb")},
+		"c":   &fstest.MapFile{Data: []byte("cccc")},
+		"big": &fstest.MapFile{Data: []byte("0123456789")},
+	}}
+	h := NewHandler(fsys, WithFileCache(8, 5))
+	for _, path := range []string{"/a", "/b", "/a", "/c", "/big"} {
+		req := httptest.NewRequest("GET", path, nil)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if _, _, size := h.FileCacheStats(); size != 8 {
+		t.Errorf("expected 8 bytes cached, got %d", size)
+	}
+	// b was the least recently used
+	testCases := []struct {
+		path   string
+		cached bool
+	}{
+		{"/a", true},
+		{"/c", true},
+		{"/b", false},
+		{"/big", false},
+	}
+	for _, tc := range testCases {
+		opens := fsys.opens.Load()
+		req := httptest.NewRequest("GET", tc.path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: unexpected status %d", tc.path, w.Code)
+		}
+		if got := fsys.opens.Load() == opens; got != tc.cached {
+			t.Errorf("%s: expected cached %v, got %v", tc.path, tc.cached, got)
+		}
+	}
+}
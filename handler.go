@@ -1,19 +1,54 @@
 package anystatic
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
 	pathpkg "path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type Handler struct {
-	fs               fs.StatFS
-	logAccessHeaders bool
+	fs                fs.StatFS
+	logAccessHeaders  bool
+	encodings         []encodeInfo
+	methods           []string
+	spaFallback       string
+	errorPages        map[int]string
+	autoIndex         bool
+	indexFiles        []string
+	tryFiles          []string
+	denyDotfiles      bool
+	denyRules         []PathRule
+	allowRules        []PathRule
+	denyStatus        int
+	headers           map[string]string
+	accessLogAttrs    []any
+	prefix            string
+	metaCache         *metaCache
+	negCache          *negativeCache
+	fileCache         *fileCache
+	compression       *DynamicCompression
+	compressCache     *fileCache // output of dynamic compression, fileCache when set
+	precompressedOnly bool
+	mimeTypes         map[string]string
+	charsets          map[string]string
+	strictMIME        bool
+	types             map[string]string // content type by extension, see buildMIMETypes
+
+	next                http.Handler
+	fallthroughCodes    map[int]bool
+	fallthroughPrefixes []string
 }
 
 type HandlerOption func(*Handler)
@@ -24,23 +59,201 @@ func WithAccessLogHeaders(enabled bool) HandlerOption {
 	}
 }
 
+// WithResponseHeaders adds headers (e.g. Cache-Control, X-Frame-Options) to every response
+// answered by the handler; responses of the next handler (see WithNext) do not get them.
+func WithResponseHeaders(headers map[string]string) HandlerOption {
+	return func(h *Handler) {
+		if h.headers == nil {
+			h.headers = map[string]string{}
+		}
+		for k, v := range headers {
+			h.headers[k] = v
+		}
+	}
+}
+
+// WithAccessLogField adds key=value to each access log line, e.g. the virtual host name.
+func WithAccessLogField(key string, value any) HandlerOption {
+	return func(h *Handler) {
+		h.accessLogAttrs = append(h.accessLogAttrs, key, value)
+	}
+}
+
+// WithPrefix serves the filesystem under the URL path prefix (e.g. "/static"):
+// the prefix is removed before lookup, and kept in redirects and listings.
+// Requests outside the prefix go to the next handler (see WithNext), or get 404.
+func WithPrefix(prefix string) HandlerOption {
+	return func(h *Handler) {
+		h.prefix = strings.TrimSuffix("/"+strings.Trim(prefix, "/"), "/")
+	}
+}
+
+// stripPrefix returns the URL path below the mount prefix; ok is false outside of it.
+func (h *Handler) stripPrefix(urlPath string) (string, bool) {
+	if h.prefix == "" {
+		return urlPath, true
+	}
+	rest, ok := strings.CutPrefix(urlPath, h.prefix)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return "", false
+	}
+	return rest, true
+}
+
+// Encoding maps a content-coding to the file suffix of its pre-compressed variant.
+type Encoding struct {
+	Name string
+	Ext  string
+}
+
+// ParseEncoding parses "name" or "name:ext".
+// Without ext, the default suffix of a known encoding (br, zstd, gzip, deflate, compress) is used.
+func ParseEncoding(spec string) (Encoding, error) {
+	name, ext, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	name = strings.ToLower(strings.TrimSpace(name))
+	ext = strings.TrimSpace(ext)
+	if name == "" || name == "*" || name == "identity" {
+		return Encoding{}, fmt.Errorf("invalid encoding %q", spec)
+	}
+	if ext == "" {
+		ei, ok := sortorder[name]
+		if !ok {
+			return Encoding{}, fmt.Errorf("no default extension for encoding %q", name)
+		}
+		ext = ei.ext
+	}
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return Encoding{Name: name, Ext: ext}, nil
+}
+
+// ParseEncodings parses a list of ParseEncoding specs.
+func ParseEncodings(specs []string) ([]Encoding, error) {
+	res := make([]Encoding, 0, len(specs))
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		enc, err := ParseEncoding(spec)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, enc)
+	}
+	return res, nil
+}
+
+// WithEncodings sets the encodings served from pre-compressed files.
+// The list order is the server priority, used when client weights are equal.
+// Encodings not in the list are never served.
+func WithEncodings(encs ...Encoding) HandlerOption {
+	return func(h *Handler) {
+		h.encodings = make([]encodeInfo, 0, len(encs))
+		seen := map[string]bool{}
+		for _, enc := range encs {
+			if seen[enc.Name] {
+				continue
+			}
+			seen[enc.Name] = true
+			h.encodings = append(h.encodings, encodeInfo{ext: enc.Ext, encode: enc.Name, order: len(h.encodings) + 1})
+		}
+	}
+}
+
+// WithMethods sets the request methods answered with the file, besides GET and HEAD.
+// OPTIONS is always answered with the Allow header; other methods get 405.
+func WithMethods(methods ...string) HandlerOption {
+	return func(h *Handler) {
+		h.methods = []string{http.MethodGet, http.MethodHead}
+		for _, m := range methods {
+			m = strings.ToUpper(strings.TrimSpace(m))
+			if m != "" && m != http.MethodOptions && !h.methodAllowed(m) {
+				h.methods = append(h.methods, m)
+			}
+		}
+	}
+}
+
+func (h *Handler) methodAllowed(method string) bool {
+	for _, m := range h.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) allowHeader() string {
+	return strings.Join(h.methods, ", ") + ", " + http.MethodOptions
+}
+
+// WithSPAFallback serves document (e.g. "/index.html") for missing paths without an extension,
+// as single-page applications route on the client side. Empty disables the fallback.
+func WithSPAFallback(document string) HandlerOption {
+	return func(h *Handler) {
+		h.spaFallback = strings.TrimPrefix(document, "/")
+	}
+}
+
+func defaultEncodings() []encodeInfo {
+	res := make([]encodeInfo, 0, len(sortorder))
+	for _, ei := range sortorder {
+		res = append(res, ei)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].order < res[j].order })
+	return res
+}
+
 func NewHandler(fsys fs.StatFS, opts ...HandlerOption) *Handler {
 	slog.Info("handler created", "root", fsys)
-	h := &Handler{fs: fsys, logAccessHeaders: true}
+	h := &Handler{fs: fsys, logAccessHeaders: true, encodings: defaultEncodings(), methods: []string{http.MethodGet, http.MethodHead}, indexFiles: []string{"index.html"}, denyDotfiles: true, denyStatus: http.StatusNotFound}
 	for _, opt := range opts {
 		if opt != nil {
 			opt(h)
 		}
 	}
+	h.buildMIMETypes()
+	h.setDeflateExt()
+	if h.fileCache != nil && h.metaCache == nil {
+		h.metaCache = newMetaCache(defaultFileCacheMetaEntries, defaultMetaCacheTTL)
+	}
+	if h.compression != nil {
+		h.compressCache = h.fileCache
+		if h.compressCache == nil {
+			h.compressCache = newFileCache(defaultCompressCacheSize, h.compression.MaxSize)
+		}
+	}
 	return h
 }
 
+// setDeflateExt names the deflate variants of filesystems having their own (see OpenArchive)
+// with the suffix deflate is served with, or hides them when deflate is not served.
+func (h *Handler) setDeflateExt() {
+	ext := ""
+	for _, ei := range h.encodings {
+		if ei.encode == "deflate" {
+			ext = ei.ext
+		}
+	}
+	layers := []fs.StatFS{h.fs}
+	if lfs, ok := h.fs.(layeredFS); ok {
+		layers = lfs.Layers()
+	}
+	for _, layer := range layers {
+		if dv, ok := layer.(deflateVariantFS); ok {
+			dv.setDeflateExt(ext)
+		}
+	}
+}
+
 type encodeInfo struct {
 	ext    string
 	encode string
 	order  int
 }
 
+// sortorder is the default encoding table, see WithEncodings to override it.
 var sortorder = map[string]encodeInfo{
 	// brotli vs zstd: which is winner?
 	"br":       {ext: ".br", encode: "br", order: 1},
@@ -50,134 +263,348 @@ var sortorder = map[string]encodeInfo{
 	"compress": {ext: ".Z", encode: "compress", order: 5},
 }
 
-var contentTypesByExt = map[string]string{
-	".css":  "text/css; charset=utf-8",
-	".gif":  "image/gif",
-	".htm":  "text/html; charset=utf-8",
-	".html": "text/html; charset=utf-8",
-	".ico":  "image/x-icon",
-	".jpeg": "image/jpeg",
-	".jpg":  "image/jpeg",
-	".js":   "text/javascript; charset=utf-8",
-	".json": "application/json",
-	".map":  "application/json",
-	".pdf":  "application/pdf",
-	".png":  "image/png",
-	".svg":  "image/svg+xml",
-	".txt":  "text/plain; charset=utf-8",
-	".wasm": "application/wasm",
-	".webp": "image/webp",
-	".xml":  "text/xml; charset=utf-8",
-}
-
-func (h *Handler) accepts(accept string) []encodeInfo {
+// parseQValue parses a weight (RFC 9110 12.4.2). ok is false for malformed values.
+func parseQValue(s string) (float64, bool) {
+	if s == "" || len(s) > 5 || (s[0] != '0' && s[0] != '1') {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
+
+type acceptedEncoding struct {
+	info encodeInfo
+	q    float64
+}
+
+// accepts parses Accept-Encoding (RFC 9110 12.5.3).
+// It returns the acceptable encodings ordered by client weight, using the server order only to break ties,
+// and whether the identity (uncompressed) representation is acceptable.
+// Encodings weighted below identity are dropped since the client prefers the original.
+func (h *Handler) accepts(accept string) ([]encodeInfo, bool) {
 	if accept == "" {
-		return nil
+		return nil, true
 	}
 
-	counts := map[string]int{}
-	known := 0
+	weights := map[string]float64{}
+	wildcard, hasWildcard := 0.0, false
+	identity, hasIdentity := 0.0, false
 	for _, v := range strings.Split(accept, ",") {
-		vv := strings.SplitN(v, ";", 2)
-		enc := strings.TrimSpace(vv[0])
-		if _, ok := sortorder[enc]; ok {
-			counts[enc]++
-			known++
+		enc, params, _ := strings.Cut(v, ";")
+		enc = strings.ToLower(strings.TrimSpace(enc))
+		if enc == "" {
+			continue
+		}
+		q := 1.0
+		valid := true
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.ToLower(strings.TrimSpace(key)) == "q" {
+				q, valid = parseQValue(strings.TrimSpace(value))
+			}
+		}
+		if !valid {
+			continue
+		}
+		switch enc {
+		case "*":
+			wildcard, hasWildcard = q, true
+		case "identity":
+			identity, hasIdentity = q, true
+		case "x-gzip":
+			weights["gzip"] = q
+		case "x-compress":
+			weights["compress"] = q
+		default:
+			weights[enc] = q
 		}
 	}
-	if known == 0 {
-		return nil
+	if !hasIdentity {
+		// identity is acceptable by default, unless excluded by "*;q=0"
+		identity = 0.0001
+		if hasWildcard {
+			identity = wildcard
+		}
 	}
 
-	res := make([]encodeInfo, 0, known)
-	for _, key := range []string{"br", "zstd", "gzip", "deflate", "compress"} {
-		ei := sortorder[key]
-		for i := 0; i < counts[key]; i++ {
-			res = append(res, ei)
+	res := make([]acceptedEncoding, 0, len(h.encodings))
+	for _, ei := range h.encodings {
+		q, ok := weights[ei.encode]
+		if !ok {
+			if !hasWildcard {
+				continue
+			}
+			q = wildcard
+		}
+		if q <= 0 || q < identity {
+			continue
 		}
+		res = append(res, acceptedEncoding{info: ei, q: q})
 	}
-	return res
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].q != res[j].q {
+			return res[i].q > res[j].q
+		}
+		return res[i].info.order < res[j].info.order
+	})
+	encs := make([]encodeInfo, len(res))
+	for i, v := range res {
+		encs[i] = v.info
+	}
+	return encs, identity > 0
 }
 
 func (h *Handler) serveHTTP(res http.ResponseWriter, req *http.Request) int {
-	var fp fs.File = nil
-	path := strings.TrimPrefix(req.URL.Path, "/")
-	if path == "" || strings.HasSuffix(path, "/") {
-		path += "index.html"
+	urlPath, ok := h.stripPrefix(req.URL.Path)
+	if !ok {
+		slog.Debug("outside of prefix", "path", req.URL.Path, "prefix", h.prefix)
+		if h.next != nil {
+			return h.serveNext(res, req)
+		}
+		return h.writeError(res, req, http.StatusNotFound)
+	}
+	if h.next != nil && h.isFallthroughPath(req.URL.Path) {
+		return h.serveNext(res, req)
+	}
+	if !h.methodAllowed(req.Method) {
+		res.Header().Set("Allow", h.allowHeader())
+		if req.Method == http.MethodOptions && (h.next == nil || !h.fallthroughCodes[http.StatusMethodNotAllowed]) {
+			res.Header().Set("Content-Length", "0")
+			res.WriteHeader(http.StatusNoContent)
+			return http.StatusNoContent
+		}
+		return h.fail(res, req, http.StatusMethodNotAllowed)
+	}
+	if urlPath == "" && h.prefix != "" {
+		// the mount point itself, e.g. /static
+		return h.redirectSlash(res, req)
+	}
+	path := strings.TrimPrefix(urlPath, "/")
+	if h.denied(path) {
+		slog.Info("denied", "path", path)
+		return h.fail(res, req, h.denyStatus)
+	}
+	isDir := path == "" || strings.HasSuffix(path, "/")
+	name, info, err := h.lookup(path)
+	if err == nil && name != path && h.denied(name) {
+		slog.Info("denied", "path", path, "resolved", name)
+		return h.fail(res, req, h.denyStatus)
+	}
+	if err == errIsDirectory {
+		return h.redirectSlash(res, req)
+	}
+	if err != nil && isDir && h.autoIndex {
+		if code, ok := h.serveDirIndex(res, req, path); ok {
+			return code
+		}
+	}
+	if err != nil && h.spaFallback != "" && pathpkg.Ext(req.URL.Path) == "" {
+		slog.Debug("spa fallback", "path", path, "fallback", h.spaFallback)
+		name = h.spaFallback
+		info, err = h.stat(name)
+		if err == nil && info.IsDir() {
+			err = errIsDirectory
+		}
 	}
-	info, err := h.fs.Stat(path)
 	if err != nil {
-		res.WriteHeader(http.StatusNotFound)
-		slog.Error("stat failed", "path", path, "error", err)
-		return http.StatusNotFound
+		if h.negCache == nil {
+			slog.Error("stat failed", "path", name, "error", err)
+		} else if ok, suppressed := h.negCache.logAllowed(name, time.Now()); ok {
+			slog.Error("stat failed", "path", name, "error", err, "suppressed", suppressed)
+		}
+		return h.fail(res, req, http.StatusNotFound)
 	}
-	content_length := info.Size()
-	infoModSec := info.ModTime().Round(time.Second)
-	encoded := false
-	ctype := contentTypesByExt[pathpkg.Ext(path)]
-	if ctype == "" {
-		ctype = "application/octet-stream"
-		if fp0, err := h.fs.Open(path); err == nil {
-			defer fp0.Close()
-			buf := make([]byte, 512)
-			if n, err := fp0.Read(buf); err == nil || err == io.EOF {
-				if n > 0 {
-					ctype = http.DetectContentType(buf[:n])
-				}
-			} else {
-				slog.Error("read for content-type failed", "path", path, "error", err)
-			}
-		} else {
-			slog.Error("open original", "path", path, "error", err)
+	return h.serveFile(res, req, name, info, http.StatusOK)
+}
+
+// redirectSlash redirects a directory request to the slash-terminated URL, keeping the query string.
+func (h *Handler) redirectSlash(res http.ResponseWriter, req *http.Request) int {
+	loc := (&url.URL{Path: req.URL.Path + "/"}).EscapedPath()
+	if strings.HasPrefix(loc, "//") {
+		// never turn into a scheme-relative URL
+		loc = "/" + strings.TrimLeft(loc, "/")
+	}
+	if req.URL.RawQuery != "" {
+		loc += "?" + req.URL.RawQuery
+	}
+	code := http.StatusMovedPermanently
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		code = http.StatusPermanentRedirect
+	}
+	res.Header().Set("Location", loc)
+	res.WriteHeader(code)
+	return code
+}
+
+// copyBody sends size bytes of fp to w. An *os.File reaches the ResponseWriter's ReadFrom
+// (wrapped only in io.LimitedReader), so net/http can send it with sendfile; the limit also
+// keeps a file growing while sent within Content-Length.
+func copyBody(w io.Writer, fp fs.File, size int64) (int64, error) {
+	if f, ok := fp.(*os.File); ok {
+		return io.Copy(w, io.LimitReader(f, size))
+	}
+	return io.Copy(w, fp)
+}
+
+// fileFS returns the filesystem of path, whose file info is info, and its variants:
+// the layer chosen by statVariants for a missing original, variantFS otherwise.
+func (h *Handler) fileFS(path string, info fs.FileInfo) fs.StatFS {
+	if ai, ok := info.(*absentInfo); ok {
+		return ai.vfs
+	}
+	return h.variantFS(path)
+}
+
+// variantFS returns the filesystem holding path and its pre-compressed variants:
+// the layer of path for a layered filesystem, h.fs otherwise.
+func (h *Handler) variantFS(path string) fs.StatFS {
+	if lfs, ok := h.fs.(layeredFS); ok {
+		if layer, err := lfs.LayerOf(path); err == nil {
+			return layer
 		}
 	}
-	res.Header().Set("Content-Type", ctype)
+	return h.fs
+}
+
+// serveFile sends path (or its best pre-compressed variant) as the response.
+// A status other than 200 is used for error documents: validators, preconditions and ranges are skipped.
+func (h *Handler) serveFile(res http.ResponseWriter, req *http.Request, path string, info fs.FileInfo, status int) int {
+	infoModSec := info.ModTime().Round(time.Second)
+	meta := h.fileMeta(path, info)
+	res.Header().Set("Content-Type", meta.ctype)
+	if h.strictMIME {
+		res.Header().Set("X-Content-Type-Options", "nosniff")
+	}
 	res.Header().Set("Vary", "Accept-Encoding")
-	for _, ae := range h.accepts(req.Header.Get("Accept-Encoding")) {
-		encodedPath := path + ae.ext
-		if cinfo, err := h.fs.Stat(encodedPath); err == nil {
-			if cinfo.ModTime().Round(time.Second).Before(infoModSec) {
+	target, tinfo, encoding := path, info, ""
+	absent := isAbsent(info)
+	encs, identityOK := h.accepts(req.Header.Get("Accept-Encoding"))
+	for _, ae := range encs {
+		if cinfo := meta.variant(ae.ext); cinfo != nil {
+			// without the original there is nothing to compare with
+			if !absent && cinfo.ModTime().Round(time.Second).Before(infoModSec) {
 				slog.Warn("encoded file is older than original", "path", path, "ext", ae.ext, "diff", info.ModTime().Sub(cinfo.ModTime()))
 				continue
 			}
-			if cinfo.Size() > info.Size() {
+			if !absent && cinfo.Size() > info.Size() {
 				slog.Info("encoded file is larger than original, skip", "path", path, "ext", ae.ext, "original", info.Size(), "encoded", cinfo.Size())
 				continue
 			}
-			res.Header().Set("Content-Encoding", ae.encode)
-			res.Header().Set("Content-Length", strconv.FormatInt(cinfo.Size(), 10))
-			fp, err = h.fs.Open(encodedPath)
-			if err != nil {
-				res.WriteHeader(http.StatusInternalServerError)
-				slog.Error("open error", "path", path, "ext", ae.ext, "error", err)
-				return http.StatusInternalServerError
-			}
-			defer fp.Close()
 			slog.Debug("encoded file", "path", path, "ext", ae.ext)
-			encoded = true
+			target, tinfo, encoding = path+ae.ext, cinfo, ae.encode
 			break
 		}
 	}
-	if !encoded {
-		res.Header().Set("Content-Length", strconv.FormatInt(content_length, 10))
-		fp, err = h.fs.Open(path)
-		if err != nil {
-			res.WriteHeader(http.StatusInternalServerError)
-			slog.Error("open error", "path", path, "error", err)
-			return http.StatusInternalServerError
+	var body []byte // dynamically compressed or decompressed representation
+	// dynamic compression is chosen here, but only done once preconditions pass
+	var dynamic encodeInfo
+	if encoding == "" && h.compression != nil && !absent {
+		if ei, ok := h.dynamicEncoding(meta, info, encs); ok {
+			dynamic, encoding = ei, ei.encode
+		}
+	}
+	if encoding == "" && absent && identityOK {
+		if data, ok := h.decompressed(meta, path); ok {
+			body, tinfo = data, &dynamicInfo{FileInfo: info, size: int64(len(data))}
+		}
+	}
+	if encoding != "" {
+		res.Header().Set("Content-Encoding", encoding)
+	} else if absent && body == nil {
+		res.Header().Del("Content-Type")
+		slog.Info("no acceptable encoding for missing original", "path", path, "accept-encoding", req.Header.Get("Accept-Encoding"))
+		if status != http.StatusOK {
+			return h.writeErrorText(res, status)
+		}
+		return h.fail(res, req, http.StatusNotAcceptable)
+	} else if !identityOK && status == http.StatusOK {
+		res.Header().Del("Content-Type")
+		slog.Info("no acceptable encoding", "path", path, "accept-encoding", req.Header.Get("Accept-Encoding"))
+		return h.fail(res, req, http.StatusNotAcceptable)
+	}
+	etag := makeETag(tinfo, encoding)
+	if body != nil || dynamic.encode != "" {
+		// derived from the original, keeping its content hash
+		etag = makeETag(info, encoding)
+	}
+	if status == http.StatusOK {
+		if code, done := writeValidators(res, req, etag, tinfo.ModTime()); done {
+			return code
+		}
+	}
+	if dynamic.encode != "" {
+		if data, ok := h.compressDynamic(meta, path, info, dynamic); ok {
+			body, tinfo = data, &dynamicInfo{FileInfo: info, size: int64(len(data))}
+		} else {
+			// send the original after all
+			dynamic, encoding = encodeInfo{}, ""
+			res.Header().Del("Content-Encoding")
+			if !identityOK && status == http.StatusOK {
+				for _, key := range []string{"Content-Type", "ETag", "Last-Modified", "Accept-Ranges"} {
+					res.Header().Del(key)
+				}
+				return h.fail(res, req, http.StatusNotAcceptable)
+			}
+			etag = makeETag(info, "")
+			if status == http.StatusOK {
+				if code, done := writeValidators(res, req, etag, info.ModTime()); done {
+					return code
+				}
+			}
+		}
+	}
+	if req.Method == http.MethodHead {
+		res.Header().Set("Content-Length", strconv.FormatInt(tinfo.Size(), 10))
+		res.WriteHeader(status)
+		return status
+	}
+	var fp fs.File
+	var err error
+	if body != nil {
+		fp = &memFile{Reader: bytes.NewReader(body), info: tinfo}
+	} else {
+		fp, err = h.openCached(meta.vfs, target, tinfo)
+	}
+	if err != nil {
+		slog.Error("open error", "path", target, "error", err)
+		if status != http.StatusOK {
+			return h.writeErrorText(res, status)
+		}
+		if errors.Is(err, fs.ErrNotExist) && h.metaCache != nil {
+			// removed while cached
+			h.metaCache.remove(path)
+			return h.fail(res, req, http.StatusNotFound)
 		}
-		defer fp.Close()
+		return h.fail(res, req, http.StatusInternalServerError)
 	}
-	if _, err := io.Copy(res, fp); err != nil {
-		slog.Error("copy error", "path", path, "error", err)
+	defer fp.Close()
+	if spec := req.Header.Get("Range"); spec != "" && req.Method == http.MethodGet && status == http.StatusOK && ifRangeMatch(req, etag, tinfo.ModTime()) {
+		if code, ok := h.serveRange(res, fp, target, spec, tinfo.Size()); ok {
+			return code
+		}
+	}
+	res.Header().Set("Content-Length", strconv.FormatInt(tinfo.Size(), 10))
+	res.WriteHeader(status)
+	if _, err := copyBody(res, fp, tinfo.Size()); err != nil {
+		slog.Error("copy error", "path", target, "error", err)
 	}
-	return http.StatusOK
+	return status
 }
 
 func (h *Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	st := time.Now()
+	if h.next != nil {
+		req = req.WithContext(context.WithValue(req.Context(), savedHeaderKey{}, res.Header().Clone()))
+	}
+	for k, v := range h.headers {
+		res.Header().Set(k, v)
+	}
 	code := h.serveHTTP(res, req)
 	attrs := []any{"method", req.Method, "path", req.URL.Path, "remote", req.RemoteAddr, "status", code, "elapsed_ns", time.Since(st)}
+	attrs = append(attrs, h.accessLogAttrs...)
 	if h.logAccessHeaders {
 		attrs = append(attrs, "req-header", req.Header, "res-header", res.Header())
 	}
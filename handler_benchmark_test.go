@@ -3,11 +3,15 @@ package anystatic
 import (
 	"bytes"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"testing/fstest"
+	"time"
 )
 
 func muteBenchmarkLogger(b *testing.B) {
@@ -37,7 +41,7 @@ func BenchmarkAccepts(b *testing.B) {
 	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		res := h.accepts(header)
+		res, _ := h.accepts(header)
 		if len(res) == 0 {
 			b.Fatal("accepts returned empty")
 		}
@@ -114,3 +118,115 @@ func BenchmarkServeHTTP_Parallel(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkServeHTTP_MetaCache compares negotiation with and without the metadata cache
+// on an OS directory, for a file whose type is sniffed and whose only variant is .gz.
+func BenchmarkServeHTTP_MetaCache(b *testing.B) {
+	muteBenchmarkLogger(b)
+	dir := b.TempDir()
+	data := benchmarkPayload(4 * 1024)
+	if err := os.WriteFile(filepath.Join(dir, "data"), data, 0o644); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data.gz"), data[:512], 0o644); err != nil {
+		b.Fatal(err)
+	}
+	fsys, err := NewDirFS(dir, SymlinkFollow)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bc := range []struct {
+		name string
+		opts []HandlerOption
+	}{
+		{"off", nil},
+		{"on", []HandlerOption{WithMetaCache(1024, time.Minute)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			h := NewHandler(fsys, append(bc.opts, WithAccessLogHeaders(false))...)
+			req := httptest.NewRequest(http.MethodGet, "/data", nil)
+			req.Header.Set("Accept-Encoding", "br, zstd, gzip")
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, req)
+				if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+					b.Fatalf("unexpected content-encoding: %q", enc)
+				}
+			}
+		})
+	}
+}
+
+// hiddenFS hides the *os.File of a DirFS behind a wrapper, like a generic fs.FS would
+type hiddenFS struct {
+	*DirFS
+}
+
+type hiddenFile struct {
+	fs.File
+}
+
+func (h hiddenFS) Open(name string) (fs.File, error) {
+	fp, err := h.DirFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return hiddenFile{fp}, nil
+}
+
+// BenchmarkServeHTTP_Sendfile serves files over TCP from a DirFS (sendfile)
+// and from the same files without the *os.File visible (user-space copy).
+func BenchmarkServeHTTP_Sendfile(b *testing.B) {
+	muteBenchmarkLogger(b)
+	dir := b.TempDir()
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"1KB", 1 << 10},
+		{"64KB", 64 << 10},
+		{"1MB", 1 << 20},
+		{"10MB", 10 << 20},
+		{"100MB", 100 << 20},
+	}
+	for _, sz := range sizes {
+		if err := os.WriteFile(filepath.Join(dir, sz.name+".bin"), benchmarkPayload(sz.size), 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	dirfs, err := NewDirFS(dir, SymlinkFollow)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bc := range []struct {
+		name string
+		fsys fs.StatFS
+	}{
+		{"sendfile", dirfs},
+		{"copy", hiddenFS{dirfs}},
+	} {
+		srv := httptest.NewServer(NewHandler(bc.fsys, WithAccessLogHeaders(false)))
+		for _, sz := range sizes {
+			b.Run(bc.name+"/"+sz.name, func(b *testing.B) {
+				url := srv.URL + "/" + sz.name + ".bin"
+				b.SetBytes(int64(sz.size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					resp, err := srv.Client().Get(url)
+					if err != nil {
+						b.Fatal(err)
+					}
+					n, _ := io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					if n != int64(sz.size) {
+						b.Fatalf("unexpected size %d", n)
+					}
+				}
+			})
+		}
+		srv.Close()
+	}
+}
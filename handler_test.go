@@ -3,6 +3,7 @@ package anystatic
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"testing/fstest"
 )
@@ -28,10 +29,85 @@ func TestNewHandler_WithAccessLogHeaders_Enabled(t *testing.T) {
 	}
 }
 
+// TestParseEncoding tests encoding spec parsing
+func TestParseEncoding(t *testing.T) {
+	testCases := []struct {
+		spec   string
+		expect Encoding
+		fail   bool
+	}{
+		{spec: "gzip", expect: Encoding{Name: "gzip", Ext: ".gz"}},
+		{spec: " ZSTD ", expect: Encoding{Name: "zstd", Ext: ".zst"}},
+		{spec: "zstd:.zstd", expect: Encoding{Name: "zstd", Ext: ".zstd"}},
+		{spec: "xz:xz", expect: Encoding{Name: "xz", Ext: ".xz"}},
+		{spec: "xz", fail: true},
+		{spec: "identity", fail: true},
+		{spec: "", fail: true},
+	}
+	for _, tc := range testCases {
+		enc, err := ParseEncoding(tc.spec)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("%q: expected error, got %v", tc.spec, enc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tc.spec, err)
+			continue
+		}
+		if enc != tc.expect {
+			t.Errorf("%q: expected %v, got %v", tc.spec, tc.expect, enc)
+		}
+	}
+}
+
+// TestAccepts_WithEncodings tests per-handler priority and disabled encodings
+func TestAccepts_WithEncodings(t *testing.T) {
+	encs, err := ParseEncodings([]string{"zstd", "br", "gzip", "zstd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(fstest.MapFS{}, WithEncodings(encs...))
+	result, _ := h.accepts("gzip, br, zstd, compress")
+
+	expected := []string{"zstd", "br", "gzip"}
+	if len(result) != len(expected) {
+		t.Fatalf("expected %v, got %d items", expected, len(result))
+	}
+	for i, exp := range expected {
+		if result[i].encode != exp {
+			t.Errorf("at index %d: expected %q, got %q", i, exp, result[i].encode)
+		}
+	}
+}
+
+// TestServeHTTP_CustomEncodingExt tests serving a variant with a custom suffix
+func TestServeHTTP_CustomEncodingExt(t *testing.T) {
+	fsys := fstest.MapFS{
+		"test.txt":      &fstest.MapFile{Data: []byte("original content here")},
+		"test.txt.zst":  &fstest.MapFile{Data: []byte("default ext")},
+		"test.txt.zstd": &fstest.MapFile{Data: []byte("custom ext")},
+	}
+	h := NewHandler(fsys, WithEncodings(Encoding{Name: "zstd", Ext: ".zstd"}))
+
+	req := httptest.NewRequest("GET", "/test.txt", nil)
+	req.Header.Set("Accept-Encoding", "zstd")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if enc := w.Header().Get("Content-Encoding"); enc != "zstd" {
+		t.Errorf("expected Content-Encoding: zstd, got %q", enc)
+	}
+	if body := w.Body.String(); body != "custom ext" {
+		t.Errorf("expected body 'custom ext', got %q", body)
+	}
+}
+
 // TestAccepts_EmptyAcceptEncoding tests accepts() with empty header
 func TestAccepts_EmptyAcceptEncoding(t *testing.T) {
 	h := NewHandler(fstest.MapFS{})
-	result := h.accepts("")
+	result, _ := h.accepts("")
 
 	if len(result) != 0 {
 		t.Errorf("expected empty slice, got %d items", len(result))
@@ -41,7 +117,7 @@ func TestAccepts_EmptyAcceptEncoding(t *testing.T) {
 // TestAccepts_SingleEncoding tests accepts() with single encoding
 func TestAccepts_SingleEncoding(t *testing.T) {
 	h := NewHandler(fstest.MapFS{})
-	result := h.accepts("gzip")
+	result, _ := h.accepts("gzip")
 
 	if len(result) != 1 {
 		t.Errorf("expected 1 item, got %d", len(result))
@@ -56,7 +132,7 @@ func TestAccepts_SingleEncoding(t *testing.T) {
 // TestAccepts_MultipleEncodings tests accepts() with multiple encodings in priority order
 func TestAccepts_MultipleEncodings(t *testing.T) {
 	h := NewHandler(fstest.MapFS{})
-	result := h.accepts("gzip, deflate, br")
+	result, _ := h.accepts("gzip, deflate, br")
 
 	if len(result) != 3 {
 		t.Errorf("expected 3 items, got %d", len(result))
@@ -72,18 +148,17 @@ func TestAccepts_MultipleEncodings(t *testing.T) {
 	}
 }
 
-// TestAccepts_WithQualityValues tests that accepts() ignores quality values
+// TestAccepts_WithQualityValues tests that accepts() orders by quality values
 func TestAccepts_WithQualityValues(t *testing.T) {
 	h := NewHandler(fstest.MapFS{})
-	result := h.accepts("gzip;q=1.0, deflate;q=0.5")
+	result, _ := h.accepts("gzip;q=1.0, deflate;q=0.5")
 
 	if len(result) != 2 {
 		t.Errorf("expected 2 items, got %d", len(result))
 		return
 	}
 
-	// Quality values are ignored; priority order is used instead
-	// gzip (order:3), deflate (order:4)
+	// gzip (q=1.0), deflate (q=0.5)
 	expected := []string{"gzip", "deflate"}
 	for i, exp := range expected {
 		if result[i].encode != exp {
@@ -95,7 +170,7 @@ func TestAccepts_WithQualityValues(t *testing.T) {
 // TestAccepts_UnknownEncoding tests that unknown encodings are filtered out
 func TestAccepts_UnknownEncoding(t *testing.T) {
 	h := NewHandler(fstest.MapFS{})
-	result := h.accepts("unknown, gzip, br")
+	result, _ := h.accepts("unknown, gzip, br")
 
 	if len(result) != 2 {
 		t.Errorf("expected 2 items (unknown filtered), got %d", len(result))
@@ -113,7 +188,7 @@ func TestAccepts_UnknownEncoding(t *testing.T) {
 // TestAccepts_WithSpaces tests accepts() handles extra whitespace
 func TestAccepts_WithSpaces(t *testing.T) {
 	h := NewHandler(fstest.MapFS{})
-	result := h.accepts("  gzip  ,  deflate  ")
+	result, _ := h.accepts("  gzip  ,  deflate  ")
 
 	if len(result) != 2 {
 		t.Errorf("expected 2 items, got %d", len(result))
@@ -128,6 +203,94 @@ func TestAccepts_WithSpaces(t *testing.T) {
 	}
 }
 
+// TestAccepts_Weighted tests q-values, q=0, wildcard and identity handling
+func TestAccepts_Weighted(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expect   []string
+		identity bool
+	}{
+		{"gzip;q=0.5, deflate;q=0.8", []string{"deflate", "gzip"}, true},
+		{"gzip;q=0, br", []string{"br"}, true},
+		{"br;q=0.5, gzip;q=0.5", []string{"br", "gzip"}, true},
+		{"gzip, br;q=0.1", []string{"gzip", "br"}, true},
+		{"*", []string{"br", "zstd", "gzip", "deflate", "compress"}, true},
+		{"gzip;q=0.9, *;q=0.1", []string{"gzip", "br", "zstd", "deflate", "compress"}, true},
+		{"*;q=0", nil, false},
+		{"gzip, *;q=0", []string{"gzip"}, false},
+		{"gzip, identity;q=0", []string{"gzip"}, false},
+		{"gzip, *;q=0, identity", []string{"gzip"}, true},
+		{"identity", nil, true},
+		{"identity, gzip;q=0.5", nil, true},
+		{"GZIP;Q=0.5", []string{"gzip"}, true},
+		{"x-gzip", []string{"gzip"}, true},
+		{"gzip;q=2, br;q=abc, zstd", []string{"zstd"}, true},
+	}
+	for _, tc := range testCases {
+		h := NewHandler(fstest.MapFS{})
+		result, identity := h.accepts(tc.header)
+		if identity != tc.identity {
+			t.Errorf("%q: expected identity %v, got %v", tc.header, tc.identity, identity)
+		}
+		if len(result) != len(tc.expect) {
+			t.Errorf("%q: expected %v, got %d items", tc.header, tc.expect, len(result))
+			continue
+		}
+		for i, exp := range tc.expect {
+			if result[i].encode != exp {
+				t.Errorf("%q at index %d: expected %q, got %q", tc.header, i, exp, result[i].encode)
+			}
+		}
+	}
+}
+
+// TestServeHTTP_NotAcceptable tests 406 when identity is forbidden and no variant exists
+func TestServeHTTP_NotAcceptable(t *testing.T) {
+	fsys := fstest.MapFS{
+		"test.txt":    &fstest.MapFile{Data: []byte("original content")},
+		"test.txt.gz": &fstest.MapFile{Data: []byte("gz")},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/test.txt", nil)
+	req.Header.Set("Accept-Encoding", "br, identity;q=0")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("expected status %d, got %d", http.StatusNotAcceptable, w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/test.txt", nil)
+	req.Header.Set("Accept-Encoding", "gzip, *;q=0")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Errorf("expected Content-Encoding: gzip, got %q", enc)
+	}
+}
+
+// TestServeHTTP_ClientPreferenceWins tests client weights override the server order
+func TestServeHTTP_ClientPreferenceWins(t *testing.T) {
+	fsys := fstest.MapFS{
+		"test.txt":    &fstest.MapFile{Data: []byte("original content that is long enough")},
+		"test.txt.br": &fstest.MapFile{Data: []byte("br")},
+		"test.txt.gz": &fstest.MapFile{Data: []byte("gz")},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/test.txt", nil)
+	req.Header.Set("Accept-Encoding", "br;q=0.5, gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Errorf("expected Content-Encoding: gzip, got %q", enc)
+	}
+}
+
 // TestServeHTTP_FileNotFound tests 404 response
 func TestServeHTTP_FileNotFound(t *testing.T) {
 	fsys := fstest.MapFS{}
@@ -408,3 +571,259 @@ func TestServeHTTP_AllEncodingPriorities(t *testing.T) {
 		})
 	}
 }
+
+// TestServeHTTP_MethodNotAllowed tests 405 with Allow for unsupported methods
+func TestServeHTTP_MethodNotAllowed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"test.txt": &fstest.MapFile{Data: []byte("Hello, World!")},
+	}
+	h := NewHandler(fsys)
+
+	for _, method := range []string{"POST", "PUT", "DELETE", "PATCH"} {
+		req := httptest.NewRequest(method, "/test.txt", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: expected status %d, got %d", method, http.StatusMethodNotAllowed, w.Code)
+		}
+		if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS" {
+			t.Errorf("%s: unexpected Allow: %q", method, allow)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("%s: expected empty body, got %q", method, w.Body.String())
+		}
+	}
+}
+
+// TestServeHTTP_Options tests OPTIONS is answered with Allow
+func TestServeHTTP_Options(t *testing.T) {
+	h := NewHandler(fstest.MapFS{})
+
+	req := httptest.NewRequest("OPTIONS", "/anything", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS" {
+		t.Errorf("unexpected Allow: %q", allow)
+	}
+}
+
+// TestServeHTTP_WithMethods tests extra methods are served like GET
+func TestServeHTTP_WithMethods(t *testing.T) {
+	fsys := fstest.MapFS{
+		"test.txt": &fstest.MapFile{Data: []byte("Hello, World!")},
+	}
+	h := NewHandler(fsys, WithMethods("post"))
+
+	req := httptest.NewRequest("POST", "/test.txt", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if body := w.Body.String(); body != "Hello, World!" {
+		t.Errorf("expected body 'Hello, World!', got %q", body)
+	}
+
+	req = httptest.NewRequest("PUT", "/test.txt", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, POST, OPTIONS" {
+		t.Errorf("unexpected Allow: %q", allow)
+	}
+}
+
+// TestServeHTTP_Head tests HEAD sends GET headers without a body
+func TestServeHTTP_Head(t *testing.T) {
+	fsys := fstest.MapFS{
+		"test.txt":    &fstest.MapFile{Data: []byte("original content here")},
+		"test.txt.gz": &fstest.MapFile{Data: []byte("gz content")},
+	}
+	h := NewHandler(fsys)
+
+	get := httptest.NewRequest("GET", "/test.txt", nil)
+	get.Header.Set("Accept-Encoding", "gzip")
+	gw := httptest.NewRecorder()
+	h.ServeHTTP(gw, get)
+
+	head := httptest.NewRequest("HEAD", "/test.txt", nil)
+	head.Header.Set("Accept-Encoding", "gzip")
+	hw := httptest.NewRecorder()
+	h.ServeHTTP(hw, head)
+
+	if hw.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, hw.Code)
+	}
+	if hw.Body.Len() != 0 {
+		t.Errorf("expected empty body, got %q", hw.Body.String())
+	}
+	for _, key := range []string{"Content-Type", "Content-Length", "Content-Encoding", "ETag", "Last-Modified", "Vary", "Accept-Ranges"} {
+		if gw.Header().Get(key) != hw.Header().Get(key) {
+			t.Errorf("%s differs: GET %q, HEAD %q", key, gw.Header().Get(key), hw.Header().Get(key))
+		}
+	}
+}
+
+// TestServeHTTP_SPAFallback tests unknown routes get the fallback document
+func TestServeHTTP_SPAFallback(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":    &fstest.MapFile{Data: []byte("<html>app shell</html>")},
+		"index.html.gz": &fstest.MapFile{Data: []byte("gz shell")},
+		"app.js":        &fstest.MapFile{Data: []byte("console.log(1)")},
+	}
+	h := NewHandler(fsys, WithSPAFallback("/index.html"))
+
+	testCases := []struct {
+		path     string
+		encoding string
+		status   int
+		body     string
+	}{
+		{"/users/42", "", http.StatusOK, "<html>app shell</html>"},
+		{"/users/42", "gzip", http.StatusOK, "gz shell"},
+		{"/settings/", "", http.StatusOK, "<html>app shell</html>"},
+		{"/v1.2/page", "", http.StatusOK, "<html>app shell</html>"},
+		{"/app.js", "", http.StatusOK, "console.log(1)"},
+		{"/missing.js", "", http.StatusNotFound, ""},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		if tc.encoding != "" {
+			req.Header.Set("Accept-Encoding", tc.encoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, w.Code)
+		}
+		if body := w.Body.String(); body != tc.body {
+			t.Errorf("%s: expected body %q, got %q", tc.path, tc.body, body)
+		}
+		if enc := w.Header().Get("Content-Encoding"); enc != tc.encoding && tc.status == http.StatusOK {
+			t.Errorf("%s: expected Content-Encoding %q, got %q", tc.path, tc.encoding, enc)
+		}
+	}
+}
+
+// TestServeHTTP_SPAFallbackDisabled tests 404 without the fallback option
+func TestServeHTTP_SPAFallbackDisabled(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte("<html>app shell</html>")},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/users/42", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// TestServeHTTP_DirectoryRedirect tests /dir -> /dir/ with the query string kept
+func TestServeHTTP_DirectoryRedirect(t *testing.T) {
+	fsys := fstest.MapFS{
+		"docs/index.html":       &fstest.MapFile{Data: []byte("<html>Docs</html>")},
+		"with space/index.html": &fstest.MapFile{Data: []byte("<html>Space</html>")},
+	}
+	h := NewHandler(fsys, WithMethods("POST"))
+
+	testCases := []struct {
+		method   string
+		target   string
+		status   int
+		location string
+	}{
+		{"GET", "/docs", http.StatusMovedPermanently, "/docs/"},
+		{"HEAD", "/docs?a=1&b=2", http.StatusMovedPermanently, "/docs/?a=1&b=2"},
+		{"POST", "/docs", http.StatusPermanentRedirect, "/docs/"},
+		{"GET", "/with%20space", http.StatusMovedPermanently, "/with%20space/"},
+		{"GET", "/docs/", http.StatusOK, ""},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.target, tc.status, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != tc.location {
+			t.Errorf("%s %s: expected Location %q, got %q", tc.method, tc.target, tc.location, loc)
+		}
+	}
+}
+
+// TestServeHTTP_Prefix tests the mount prefix is stripped for lookup and kept in redirects and listings
+func TestServeHTTP_Prefix(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":      &fstest.MapFile{Data: []byte("root")},
+		"docs/index.html": &fstest.MapFile{Data: []byte("docs")},
+		"files/a.txt":     &fstest.MapFile{Data: []byte("a")},
+	}
+	h := NewHandler(fsys, WithPrefix("/static/"), WithAutoIndex(true))
+
+	testCases := []struct {
+		target   string
+		status   int
+		location string
+		body     string
+	}{
+		{"/static/", http.StatusOK, "", "root"},
+		{"/static", http.StatusMovedPermanently, "/static/", ""},
+		{"/static/docs", http.StatusMovedPermanently, "/static/docs/", ""},
+		{"/static/docs/", http.StatusOK, "", "docs"},
+		{"/static/files/a.txt", http.StatusOK, "", "a"},
+		{"/files/a.txt", http.StatusNotFound, "", ""},
+		{"/staticfiles/a.txt", http.StatusNotFound, "", ""},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.target, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.target, tc.status, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != tc.location {
+			t.Errorf("%s: expected Location %q, got %q", tc.target, tc.location, loc)
+		}
+		if tc.body != "" && w.Body.String() != tc.body {
+			t.Errorf("%s: expected body %q, got %q", tc.target, tc.body, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/static/files/?format=json", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"path":"/static/files/"`) {
+		t.Errorf("expected prefixed path in listing, got %s", w.Body.String())
+	}
+}
+
+// TestServeHTTP_PrefixNext tests requests outside the prefix go to the next handler
+func TestServeHTTP_PrefixNext(t *testing.T) {
+	fsys := fstest.MapFS{"a.txt": &fstest.MapFile{Data: []byte("a")}}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := NewHandler(fsys, WithPrefix("/static"), WithNext(next), WithFallthroughCodes())
+
+	req := httptest.NewRequest("GET", "/api/a.txt", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusTeapot {
+		t.Errorf("expected status %d, got %d", http.StatusTeapot, w.Code)
+	}
+	req = httptest.NewRequest("GET", "/static/a.txt", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
@@ -0,0 +1,85 @@
+package anystatic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"log/slog"
+)
+
+// contentHasher is implemented by file infos that carry a content hash.
+// makeETag uses it instead of modification time and size.
+type contentHasher interface {
+	ContentHash() string
+}
+
+// HashFS serves a filesystem without usable modification times, such as embed.FS.
+// Stat results carry a content hash, so ETags change when content does.
+type HashFS struct {
+	fsys   fs.FS
+	hashes map[string]string
+}
+
+// NewHashFS wraps fsys with hashes, a map from file path to content hash
+// usually computed at build time by ContentHashes. When hashes is nil they are computed now.
+func NewHashFS(fsys fs.FS, hashes map[string]string) (*HashFS, error) {
+	if hashes == nil {
+		var err error
+		if hashes, err = ContentHashes(fsys); err != nil {
+			return nil, err
+		}
+		slog.Info("content hashes computed", "files", len(hashes))
+	}
+	return &HashFS{fsys: fsys, hashes: hashes}, nil
+}
+
+// ContentHashes returns the hash of every regular file in fsys, keyed by path.
+func ContentHashes(fsys fs.FS) (map[string]string, error) {
+	res := map[string]string{}
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		fp, err := fsys.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		sum := sha256.New()
+		if _, err := io.Copy(sum, fp); err != nil {
+			return err
+		}
+		res[path] = hex.EncodeToString(sum.Sum(nil)[:16])
+		return nil
+	})
+	return res, err
+}
+
+type hashedInfo struct {
+	fs.FileInfo
+	hash string
+}
+
+func (i hashedInfo) ContentHash() string {
+	return i.hash
+}
+
+func (h *HashFS) Open(name string) (fs.File, error) {
+	return h.fsys.Open(name)
+}
+
+func (h *HashFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if hash, ok := h.hashes[name]; ok && !info.IsDir() {
+		return hashedInfo{FileInfo: info, hash: hash}, nil
+	}
+	return info, nil
+}
+
+func (h *HashFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(h.fsys, name)
+}
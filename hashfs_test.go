@@ -0,0 +1,102 @@
+package anystatic
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// onlyFS hides everything but Open, like embed.FS hides Stat
+type onlyFS struct {
+	fs.FS
+}
+
+// TestContentHashes tests hashes depend on content only
+func TestContentHashes(t *testing.T) {
+	site := fstest.MapFS{
+		"index.html":    &fstest.MapFile{Data: []byte(strings.Repeat("index ", 20))},
+		"index.html.gz": &fstest.MapFile{Data: []byte("compressed")},
+	}
+	hashes, err := ContentHashes(onlyFS{site})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 2 || hashes["index.html"] == "" || hashes["index.html"] == hashes["index.html.gz"] {
+		t.Errorf("unexpected hashes %v", hashes)
+	}
+	site["index.html"].Data = []byte("changed")
+	other, _ := ContentHashes(site)
+	if other["index.html"] == hashes["index.html"] || other["index.html.gz"] != hashes["index.html.gz"] {
+		t.Errorf("unexpected hashes after change %v", other)
+	}
+}
+
+// TestServeHTTP_HashFS tests ETags come from content hashes and zero mtimes are not sent
+func TestServeHTTP_HashFS(t *testing.T) {
+	site := fstest.MapFS{
+		"index.html":    &fstest.MapFile{Data: []byte(strings.Repeat("index ", 20))},
+		"index.html.gz": &fstest.MapFile{Data: []byte("compressed")},
+	}
+	hashes, _ := ContentHashes(site)
+	fsys, err := NewHashFS(onlyFS{site}, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	etag := w.Header().Get("ETag")
+	if etag != "\""+hashes["index.html"]+"\"" {
+		t.Errorf("expected hash etag, got %q", etag)
+	}
+	if lm := w.Header().Get("Last-Modified"); lm != "" {
+		t.Errorf("unexpected Last-Modified %q", lm)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get("ETag"); got != "\""+hashes["index.html.gz"]+"-gzip\"" {
+		t.Errorf("unexpected gzip etag %q", got)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected status %d, got %d", http.StatusNotModified, w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-Modified-Since", "Mon, 01 Jan 2024 00:00:00 GMT")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d with unknown mtime, got %d", http.StatusOK, w.Code)
+	}
+}
+
+// TestNewHashFS_Compute tests hashes are computed when not given
+func TestNewHashFS_Compute(t *testing.T) {
+	site := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(strings.Repeat("index ", 20))},
+	}
+	fsys, err := NewHashFS(onlyFS{site}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := fsys.Stat("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch, ok := info.(contentHasher); !ok || ch.ContentHash() == "" {
+		t.Errorf("expected content hash, got %v", info)
+	}
+}
@@ -0,0 +1,143 @@
+package anystatic
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// errIsDirectory is returned by lookup when the request path names a directory
+// and should be redirected to the slash-terminated URL.
+var errIsDirectory = errors.New("is a directory")
+
+// WithIndexFiles sets the index documents tried, in order, for directory requests (default index.html).
+func WithIndexFiles(names ...string) HandlerOption {
+	return func(h *Handler) {
+		h.indexFiles = nil
+		for _, name := range names {
+			if name = strings.Trim(strings.TrimSpace(name), "/"); name != "" {
+				h.indexFiles = append(h.indexFiles, name)
+			}
+		}
+	}
+}
+
+// WithTryFiles sets nginx try_files style candidates, where $uri is the request path.
+// e.g. "$uri", "$uri.html", "$uri/" lets /about resolve to about.html.
+// Candidates ending with "/" are directories and try the index files.
+// Without try files, only "$uri" is tried.
+func WithTryFiles(patterns ...string) HandlerOption {
+	return func(h *Handler) {
+		h.tryFiles = nil
+		for _, pattern := range patterns {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				h.tryFiles = append(h.tryFiles, pattern)
+			}
+		}
+	}
+}
+
+// candidates lists the files tried for path (the request path without the leading slash).
+func (h *Handler) candidates(path string) []string {
+	patterns := h.tryFiles
+	if len(patterns) == 0 {
+		patterns = []string{"$uri"}
+	}
+	res := make([]string, 0, len(patterns)+len(h.indexFiles))
+	for _, pattern := range patterns {
+		name := strings.TrimPrefix(strings.ReplaceAll(pattern, "$uri", path), "/")
+		if name == "" || strings.HasSuffix(name, "/") {
+			for _, idx := range h.indexFiles {
+				res = append(res, name+idx)
+			}
+			continue
+		}
+		res = append(res, name)
+	}
+	return res
+}
+
+// lookup returns the first candidate of path that is a regular file.
+// errIsDirectory is returned when path is a directory requested without the trailing slash.
+func (h *Handler) lookup(path string) (string, fs.FileInfo, error) {
+	var firstErr error
+	sawDir := false
+	for _, name := range h.candidates(path) {
+		info, err := h.stat(name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if info.IsDir() {
+			if name == path {
+				sawDir = true
+			}
+			continue
+		}
+		if h.isIndexOf(name, path) {
+			// "$uri/" matched without the trailing slash: redirect so relative links work
+			return path, nil, errIsDirectory
+		}
+		return name, info, nil
+	}
+	if sawDir {
+		return path, nil, errIsDirectory
+	}
+	if firstErr == nil {
+		firstErr = fs.ErrNotExist
+	}
+	return path, nil, firstErr
+}
+
+// isIndexOf reports whether name is an index file of the directory path (given without trailing slash).
+func (h *Handler) isIndexOf(name, path string) bool {
+	if path == "" || strings.HasSuffix(path, "/") {
+		return false
+	}
+	rest, ok := strings.CutPrefix(name, path+"/")
+	if !ok {
+		return false
+	}
+	for _, idx := range h.indexFiles {
+		if rest == idx {
+			return true
+		}
+	}
+	return false
+}
+
+// stat is h.fs.Stat, answered from the metadata cache while the entry is fresh
+// and from the negative cache for known missing names.
+// With WithPrecompressedOnly, a missing name with variants gives an absentInfo.
+func (h *Handler) stat(name string) (fs.FileInfo, error) {
+	now := time.Now()
+	if h.metaCache != nil {
+		if meta, fresh := h.metaCache.get(name, now); fresh {
+			return meta.info, nil
+		}
+	}
+	if h.negCache != nil {
+		h.negCache.checkRoot(h.fs, now)
+		if h.negCache.has(name, now) {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: errNegativeCached}
+		}
+	}
+	info, err := h.fs.Stat(name)
+	if err != nil && h.precompressedOnly && errors.Is(err, fs.ErrNotExist) {
+		if vinfo := h.statVariants(name); vinfo != nil {
+			info, err = vinfo, nil
+		}
+	}
+	if err != nil {
+		if h.metaCache != nil {
+			h.metaCache.remove(name)
+		}
+		if h.negCache != nil && errors.Is(err, fs.ErrNotExist) {
+			h.negCache.add(name, now)
+		}
+	}
+	return info, err
+}
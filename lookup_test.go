@@ -0,0 +1,105 @@
+package anystatic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+// TestCandidates tests try_files expansion
+func TestCandidates(t *testing.T) {
+	h := NewHandler(fstest.MapFS{}, WithIndexFiles("index.html", "index.htm"), WithTryFiles("$uri", "$uri.html", "$uri/"))
+
+	testCases := []struct {
+		path   string
+		expect []string
+	}{
+		{"about", []string{"about", "about.html", "about/index.html", "about/index.htm"}},
+		{"", []string{"index.html", "index.htm", ".html", "index.html", "index.htm"}},
+	}
+	for _, tc := range testCases {
+		got := h.candidates(tc.path)
+		if len(got) != len(tc.expect) {
+			t.Errorf("%q: expected %v, got %v", tc.path, tc.expect, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.expect[i] {
+				t.Errorf("%q[%d]: expected %q, got %q", tc.path, i, tc.expect[i], got[i])
+			}
+		}
+	}
+}
+
+// TestServeHTTP_IndexFiles tests the index file list is tried in order
+func TestServeHTTP_IndexFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"blog/default.htm": &fstest.MapFile{Data: []byte("blog index")},
+		"docs/index.htm":   &fstest.MapFile{Data: []byte("docs htm")},
+		"docs/index.html":  &fstest.MapFile{Data: []byte("docs html")},
+		"guide/index.html": &fstest.MapFile{Data: []byte("guide index")},
+	}
+	h := NewHandler(fsys, WithIndexFiles("index.htm", "index.html", "default.htm"))
+
+	testCases := []struct {
+		path string
+		body string
+	}{
+		{"/docs/", "docs htm"},
+		{"/blog/", "blog index"},
+		{"/guide/", "guide index"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", tc.path, http.StatusOK, w.Code)
+		}
+		if body := w.Body.String(); body != tc.body {
+			t.Errorf("%s: expected body %q, got %q", tc.path, tc.body, body)
+		}
+	}
+}
+
+// TestServeHTTP_TryFiles tests clean URLs resolve through try_files candidates
+func TestServeHTTP_TryFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"about.html":       &fstest.MapFile{Data: []byte("about page")},
+		"about.html.gz":    &fstest.MapFile{Data: []byte("gz about")},
+		"docs/index.html":  &fstest.MapFile{Data: []byte("docs html")},
+		"guide/index.html": &fstest.MapFile{Data: []byte("guide index")},
+		"guide.html":       &fstest.MapFile{Data: []byte("guide page")},
+	}
+	h := NewHandler(fsys, WithTryFiles("$uri", "$uri.html", "$uri/"))
+
+	testCases := []struct {
+		path     string
+		encoding string
+		status   int
+		body     string
+	}{
+		{"/about", "", http.StatusOK, "about page"},
+		{"/about", "gzip", http.StatusOK, "gz about"},
+		{"/about.html", "", http.StatusOK, "about page"},
+		{"/guide", "", http.StatusOK, "guide page"},
+		{"/guide/", "", http.StatusOK, "guide index"},
+		{"/docs", "", http.StatusMovedPermanently, ""},
+		{"/missing", "", http.StatusNotFound, ""},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		if tc.encoding != "" {
+			req.Header.Set("Accept-Encoding", tc.encoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, w.Code)
+		}
+		if body := w.Body.String(); body != tc.body {
+			t.Errorf("%s: expected body %q, got %q", tc.path, tc.body, body)
+		}
+	}
+}
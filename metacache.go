@@ -0,0 +1,133 @@
+package anystatic
+
+import (
+	"container/list"
+	"io/fs"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// defaultMetaCacheTTL is used by WithMetaCache when ttl is not positive.
+const defaultMetaCacheTTL = 5 * time.Second
+
+// WithMetaCache keeps, for up to maxEntries files, the stat result, the available
+// pre-compressed variants and the content type, so a cached file is negotiated
+// without filesystem calls. An entry is trusted for ttl (default 5s); after that the
+// file and its variants are stat'ed again, and the content type is kept while
+// the modification time and size of the file are unchanged.
+func WithMetaCache(maxEntries int, ttl time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if maxEntries <= 0 {
+			h.metaCache = nil
+			return
+		}
+		if ttl <= 0 {
+			ttl = defaultMetaCacheTTL
+		}
+		h.metaCache = newMetaCache(maxEntries, ttl)
+	}
+}
+
+// fileMeta is the negotiation data of one file.
+type fileMeta struct {
+	name    string
+	info    fs.FileInfo
+	ctype   string
+	vfs     fs.StatFS
+	checked time.Time
+	// variants maps the suffix of every encoding to its file info (nil if missing).
+	// It is nil when not probed: variant then stats on demand.
+	variants map[string]fs.FileInfo
+}
+
+// variant returns the file info of the variant with suffix ext, or nil.
+func (m *fileMeta) variant(ext string) fs.FileInfo {
+	if m.variants != nil {
+		return m.variants[ext]
+	}
+	info, err := m.vfs.Stat(m.name + ext)
+	if err != nil {
+		return nil
+	}
+	return info
+}
+
+type metaCache struct {
+	mu      sync.Mutex
+	max     int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used *fileMeta
+}
+
+func newMetaCache(maxEntries int, ttl time.Duration) *metaCache {
+	return &metaCache{max: maxEntries, ttl: ttl, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+// get returns the entry of name and whether it is still within ttl.
+func (c *metaCache) get(name string, now time.Time) (*fileMeta, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[name]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	meta := elem.Value.(*fileMeta)
+	return meta, now.Sub(meta.checked) < c.ttl
+}
+
+func (c *metaCache) put(meta *fileMeta) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[meta.name]; ok {
+		elem.Value = meta
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[meta.name] = c.lru.PushFront(meta)
+	for c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*fileMeta).name)
+	}
+}
+
+func (c *metaCache) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[name]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, name)
+	}
+}
+
+// fileMeta returns the negotiation data of name, whose current file info is info.
+func (h *Handler) fileMeta(name string, info fs.FileInfo) *fileMeta {
+	if h.metaCache == nil {
+		return &fileMeta{name: name, info: info, ctype: h.contentType(name, info), vfs: h.fileFS(name, info)}
+	}
+	now := time.Now()
+	cached, fresh := h.metaCache.get(name, now)
+	if fresh {
+		return cached
+	}
+	meta := &fileMeta{name: name, info: info, vfs: h.fileFS(name, info), checked: now}
+	if cached != nil && cached.info.ModTime().Equal(info.ModTime()) && cached.info.Size() == info.Size() {
+		meta.ctype = cached.ctype
+	} else {
+		meta.ctype = h.contentType(name, info)
+	}
+	meta.variants = make(map[string]fs.FileInfo, len(h.encodings))
+	for _, ei := range h.encodings {
+		if vinfo, err := meta.vfs.Stat(name + ei.ext); err == nil && !vinfo.IsDir() {
+			meta.variants[ei.ext] = vinfo
+		} else {
+			meta.variants[ei.ext] = nil
+		}
+	}
+	slog.Debug("metadata cached", "path", name, "content-type", meta.ctype)
+	h.metaCache.put(meta)
+	return meta
+}
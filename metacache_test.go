@@ -0,0 +1,131 @@
+package anystatic
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// countingFS counts Stat and Open calls
+type countingFS struct {
+	fstest.MapFS
+	stats atomic.Int64
+	opens atomic.Int64
+}
+
+func (c *countingFS) Stat(name string) (fs.FileInfo, error) {
+	c.stats.Add(1)
+	return c.MapFS.Stat(name)
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.opens.Add(1)
+	return c.MapFS.Open(name)
+}
+
+// TestMetaCache_Hit tests a cached file is negotiated without Stat calls
+func TestMetaCache_Hit(t *testing.T) {
+	fsys := &countingFS{MapFS: fstest.MapFS{
+		"data": &fstest.MapFile{Data: []byte("<html>data</html>")},
+	}}
+	h := NewHandler(fsys, WithMetaCache(10, time.Hour))
+
+	req := httptest.NewRequest("GET", "/data", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	stats, opens := fsys.stats.Load(), fsys.opens.Load()
+
+	req = httptest.NewRequest("GET", "/data", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if fsys.stats.Load() != stats {
+		t.Errorf("expected no Stat on hit, got %d", fsys.stats.Load()-stats)
+	}
+	if fsys.opens.Load() != opens+1 {
+		t.Errorf("expected only the body Open on hit, got %d", fsys.opens.Load()-opens)
+	}
+}
+
+// TestMetaCache_Expire tests variants are probed again after ttl and the type is sniffed again on change
+func TestMetaCache_Expire(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := &countingFS{MapFS: fstest.MapFS{
+		"data": &fstest.MapFile{Data: []byte("<html>data</html>"), ModTime: modTime},
+	}}
+	h := NewHandler(fsys, WithMetaCache(10, time.Hour))
+	req := httptest.NewRequest("GET", "/data", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	fsys.MapFS["data.gz"] = &fstest.MapFile{Data: []byte("gz"), ModTime: modTime}
+	req = httptest.NewRequest("GET", "/data", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ce := w.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("expected cached negotiation, got %q", ce)
+	}
+
+	h.metaCache.ttl = 0
+	opens := fsys.opens.Load()
+	req = httptest.NewRequest("GET", "/data", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ce := w.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Errorf("expected new variant after ttl, got %q", ce)
+	}
+	if fsys.opens.Load() != opens+1 {
+		t.Errorf("expected content type kept for unchanged file")
+	}
+
+	fsys.MapFS["data"] = &fstest.MapFile{Data: []byte("plain text, longer than before"), ModTime: modTime.Add(time.Hour)}
+	req = httptest.NewRequest("GET", "/data", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("expected sniffed again after change, got %q", ct)
+	}
+
+	h.metaCache.ttl = time.Hour
+	req = httptest.NewRequest("GET", "/data", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	delete(fsys.MapFS, "data")
+	req = httptest.NewRequest("GET", "/data", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d after removal, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// TestMetaCache_Bound tests the least recently used entry is evicted
+func TestMetaCache_Bound(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data":      &fstest.MapFile{Data: []byte("<html>data</html>")},
+		"style.css": &fstest.MapFile{Data: []byte("body{}")},
+	}
+	h := NewHandler(fsys, WithMetaCache(1, time.Hour))
+	for _, path := range []string{"/data", "/style.css"} {
+		req := httptest.NewRequest("GET", path, nil)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if len(h.metaCache.entries) != 1 || h.metaCache.lru.Len() != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(h.metaCache.entries))
+	}
+	if _, ok := h.metaCache.entries["style.css"]; !ok {
+		t.Errorf("expected the latest entry to stay")
+	}
+}
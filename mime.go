@@ -0,0 +1,289 @@
+package anystatic
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"strings"
+)
+
+// defaultCharset is added to text types of WithMIMETypes without a charset.
+const defaultCharset = "utf-8"
+
+// contentTypesByExt is the built-in MIME table, see WithMIMETypes to extend it.
+var contentTypesByExt = map[string]string{
+	// text
+	".css":      "text/css; charset=utf-8",
+	".csv":      "text/csv; charset=utf-8",
+	".htm":      "text/html; charset=utf-8",
+	".html":     "text/html; charset=utf-8",
+	".ics":      "text/calendar; charset=utf-8",
+	".js":       "text/javascript; charset=utf-8",
+	".markdown": "text/markdown; charset=utf-8",
+	".md":       "text/markdown; charset=utf-8",
+	".mjs":      "text/javascript; charset=utf-8",
+	".shtml":    "text/html; charset=utf-8",
+	".tsv":      "text/tab-separated-values; charset=utf-8",
+	".txt":      "text/plain; charset=utf-8",
+	".vcf":      "text/vcard; charset=utf-8",
+	".vtt":      "text/vtt; charset=utf-8",
+	".xml":      "text/xml; charset=utf-8",
+	".yaml":     "application/yaml",
+	".yml":      "application/yaml",
+
+	// application
+	".7z":          "application/x-7z-compressed",
+	".atom":        "application/atom+xml",
+	".bin":         "application/octet-stream",
+	".bz2":         "application/x-bzip2",
+	".doc":         "application/msword",
+	".docx":        "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".epub":        "application/epub+zip",
+	".geojson":     "application/geo+json",
+	".gz":          "application/gzip",
+	".jar":         "application/java-archive",
+	".json":        "application/json",
+	".jsonld":      "application/ld+json",
+	".map":         "application/json",
+	".odp":         "application/vnd.oasis.opendocument.presentation",
+	".ods":         "application/vnd.oasis.opendocument.spreadsheet",
+	".odt":         "application/vnd.oasis.opendocument.text",
+	".ogx":         "application/ogg",
+	".pdf":         "application/pdf",
+	".ppt":         "application/vnd.ms-powerpoint",
+	".pptx":        "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".rar":         "application/vnd.rar",
+	".rss":         "application/rss+xml",
+	".rtf":         "application/rtf",
+	".tar":         "application/x-tar",
+	".tgz":         "application/gzip",
+	".wasm":        "application/wasm",
+	".webmanifest": "application/manifest+json",
+	".xhtml":       "application/xhtml+xml",
+	".xls":         "application/vnd.ms-excel",
+	".xlsx":        "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".xz":          "application/x-xz",
+	".zip":         "application/zip",
+	".zst":         "application/zstd",
+
+	// image
+	".apng": "image/apng",
+	".avif": "image/avif",
+	".bmp":  "image/bmp",
+	".gif":  "image/gif",
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".ico":  "image/x-icon",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".jxl":  "image/jxl",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".svgz": "image/svg+xml",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".webp": "image/webp",
+
+	// font
+	".eot":   "application/vnd.ms-fontobject",
+	".otf":   "font/otf",
+	".ttc":   "font/collection",
+	".ttf":   "font/ttf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+
+	// audio
+	".aac":  "audio/aac",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".mid":  "audio/midi",
+	".midi": "audio/midi",
+	".mp3":  "audio/mpeg",
+	".oga":  "audio/ogg",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".weba": "audio/webm",
+
+	// video
+	".3gp":  "video/3gpp",
+	".avi":  "video/x-msvideo",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".m4v":  "video/mp4",
+	".mkv":  "video/x-matroska",
+	".mov":  "video/quicktime",
+	".mp4":  "video/mp4",
+	".mpd":  "application/dash+xml",
+	".mpeg": "video/mpeg",
+	".mpg":  "video/mpeg",
+	".ogv":  "video/ogg",
+	".ts":   "video/mp2t",
+	".webm": "video/webm",
+}
+
+// normalizeExt returns ext lowercased with a leading dot.
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// withDefaultCharset adds charset=utf-8 to text types (and JavaScript) without a charset.
+func withDefaultCharset(ctype string) string {
+	mediatype, params, err := mime.ParseMediaType(ctype)
+	if err != nil || params["charset"] != "" {
+		return ctype
+	}
+	if !strings.HasPrefix(mediatype, "text/") && mediatype != "application/javascript" {
+		return ctype
+	}
+	params["charset"] = defaultCharset
+	return mime.FormatMediaType(mediatype, params)
+}
+
+// WithMIMETypes adds or replaces content types by extension (".woff2" or "woff2", any case),
+// on top of the built-in table. Text types without a charset get charset=utf-8.
+// Calls add up, later ones winning.
+func WithMIMETypes(types map[string]string) HandlerOption {
+	return func(h *Handler) {
+		if h.mimeTypes == nil {
+			h.mimeTypes = map[string]string{}
+		}
+		for ext, ctype := range types {
+			if ext = normalizeExt(ext); ext != "" && strings.TrimSpace(ctype) != "" {
+				h.mimeTypes[ext] = withDefaultCharset(strings.TrimSpace(ctype))
+			}
+		}
+	}
+}
+
+// WithCharsets sets the charset parameter of the content type per extension,
+// e.g. {".txt": "shift_jis"}. An empty charset removes the parameter.
+func WithCharsets(charsets map[string]string) HandlerOption {
+	return func(h *Handler) {
+		if h.charsets == nil {
+			h.charsets = map[string]string{}
+		}
+		for ext, charset := range charsets {
+			if ext = normalizeExt(ext); ext != "" {
+				h.charsets[ext] = strings.ToLower(strings.TrimSpace(charset))
+			}
+		}
+	}
+}
+
+// WithStrictMIME disables content sniffing: files with an unknown extension are sent as
+// application/octet-stream, and every file response carries X-Content-Type-Options: nosniff.
+func WithStrictMIME(enabled bool) HandlerOption {
+	return func(h *Handler) {
+		h.strictMIME = enabled
+	}
+}
+
+// LoadMIMETypes reads a mime.types file (as /etc/mime.types): lines of a media type followed
+// by its extensions, "#" starting a comment. It returns the content type by extension.
+func LoadMIMETypes(file string) (map[string]string, error) {
+	fp, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	types, err := parseMIMETypes(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return types, nil
+}
+
+func parseMIMETypes(r io.Reader) (map[string]string, error) {
+	types := map[string]string{}
+	sc := bufio.NewScanner(r)
+	lineno := 0
+	for sc.Scan() {
+		lineno++
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, _, err := mime.ParseMediaType(fields[0]); err != nil || !strings.Contains(fields[0], "/") {
+			return nil, fmt.Errorf("line %d: invalid media type %q", lineno, fields[0])
+		}
+		for _, ext := range fields[1:] {
+			types[normalizeExt(ext)] = strings.ToLower(fields[0])
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
+// buildMIMETypes merges the built-in table, WithMIMETypes and WithCharsets into h.types.
+func (h *Handler) buildMIMETypes() {
+	if len(h.mimeTypes) == 0 && len(h.charsets) == 0 {
+		h.types = contentTypesByExt
+		return
+	}
+	h.types = make(map[string]string, len(contentTypesByExt)+len(h.mimeTypes))
+	for ext, ctype := range contentTypesByExt {
+		h.types[ext] = ctype
+	}
+	for ext, ctype := range h.mimeTypes {
+		h.types[ext] = ctype
+	}
+	for ext, charset := range h.charsets {
+		ctype, ok := h.types[ext]
+		if !ok {
+			slog.Warn("charset for unknown extension", "ext", ext, "charset", charset)
+			continue
+		}
+		mediatype, params, err := mime.ParseMediaType(ctype)
+		if err != nil {
+			slog.Warn("invalid content type", "ext", ext, "content-type", ctype, "error", err)
+			continue
+		}
+		if charset == "" {
+			delete(params, "charset")
+		} else {
+			params["charset"] = charset
+		}
+		h.types[ext] = mime.FormatMediaType(mediatype, params)
+	}
+}
+
+// contentType returns the content type of path: by extension, else sniffed from the
+// first 512 bytes unless strict or info stands for a missing original.
+func (h *Handler) contentType(path string, info fs.FileInfo) string {
+	if ctype := h.types[strings.ToLower(pathpkg.Ext(path))]; ctype != "" {
+		return ctype
+	}
+	ctype := "application/octet-stream"
+	if h.strictMIME || isAbsent(info) {
+		// nothing to sniff
+		return ctype
+	}
+	fp, err := h.fs.Open(path)
+	if err != nil {
+		slog.Error("open original", "path", path, "error", err)
+		return ctype
+	}
+	defer fp.Close()
+	buf := make([]byte, 512)
+	if n, err := fp.Read(buf); err == nil || err == io.EOF {
+		if n > 0 {
+			ctype = http.DetectContentType(buf[:n])
+		}
+	} else {
+		slog.Error("read for content-type failed", "path", path, "error", err)
+	}
+	return ctype
+}
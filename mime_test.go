@@ -0,0 +1,113 @@
+package anystatic
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// TestContentType_Builtin tests the built-in table and sniffing of unknown extensions
+func TestContentType_Builtin(t *testing.T) {
+	fsys := fstest.MapFS{
+		"font.woff2":       &fstest.MapFile{Data: []byte("<html>content</html>")},
+		"photo.AVIF":       &fstest.MapFile{Data: []byte("<html>content</html>")},
+		"app.mjs":          &fstest.MapFile{Data: []byte("<html>content</html>")},
+		"site.webmanifest": &fstest.MapFile{Data: []byte("<html>content</html>")},
+		"movie.mp4":        &fstest.MapFile{Data: []byte("<html>content</html>")},
+		"data.csv":         &fstest.MapFile{Data: []byte("<html>content</html>")},
+		"unknown.xyz":      &fstest.MapFile{Data: []byte("<html>content</html>")},
+	}
+	h := NewHandler(fsys)
+	for path, expect := range map[string]string{
+		"/font.woff2":       "font/woff2",
+		"/photo.AVIF":       "image/avif",
+		"/app.mjs":          "text/javascript; charset=utf-8",
+		"/site.webmanifest": "application/manifest+json",
+		"/movie.mp4":        "video/mp4",
+		"/data.csv":         "text/csv; charset=utf-8",
+		"/unknown.xyz":      "text/html; charset=utf-8",
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if ct := w.Header().Get("Content-Type"); ct != expect {
+			t.Errorf("%s: expected %q, got %q", path, expect, ct)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "" {
+			t.Errorf("%s: unexpected nosniff", path)
+		}
+	}
+}
+
+// TestContentType_Options tests overrides, charsets and strict mode
+func TestContentType_Options(t *testing.T) {
+	fsys := fstest.MapFS{
+		"page.webc":   &fstest.MapFile{Data: []byte("<html>content</html>")},
+		"movie.mp4":   &fstest.MapFile{Data: []byte("<html>content</html>")},
+		"notes.txt":   &fstest.MapFile{Data: []byte("<html>content</html>")},
+		"font.woff2":  &fstest.MapFile{Data: []byte("<html>content</html>")},
+		"unknown.xyz": &fstest.MapFile{Data: []byte("<html>content</html>")},
+	}
+	h := NewHandler(fsys,
+		WithMIMETypes(map[string]string{"webc": "text/html", ".MP4": "application/mp4"}),
+		WithCharsets(map[string]string{".txt": "Shift_JIS", ".webc": "", ".woff2": "utf-8"}),
+		WithStrictMIME(true))
+	for path, expect := range map[string]string{
+		"/page.webc":   "text/html",
+		"/movie.mp4":   "application/mp4",
+		"/notes.txt":   "text/plain; charset=shift_jis",
+		"/font.woff2":  "font/woff2; charset=utf-8",
+		"/unknown.xyz": "application/octet-stream",
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if ct := w.Header().Get("Content-Type"); ct != expect {
+			t.Errorf("%s: expected %q, got %q", path, expect, ct)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: expected nosniff", path)
+		}
+	}
+	if contentTypesByExt[".txt"] != "text/plain; charset=utf-8" {
+		t.Errorf("built-in table modified: %q", contentTypesByExt[".txt"])
+	}
+}
+
+// TestLoadMIMETypes tests mime.types files
+func TestLoadMIMETypes(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "mime.types")
+	conf := "# comment\n\ntext/x-webc\t\twebc WEBC2 # trailing\napplication/x-empty\napplication/mp4 mp4\n"
+	if err := os.WriteFile(file, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	types, err := LoadMIMETypes(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 3 || types[".webc"] != "text/x-webc" || types[".webc2"] != "text/x-webc" || types[".mp4"] != "application/mp4" {
+		t.Errorf("unexpected types %v", types)
+	}
+	fsys := fstest.MapFS{
+		"page.webc": &fstest.MapFile{Data: []byte("<html>content</html>")},
+	}
+	h := NewHandler(fsys, WithMIMETypes(types))
+	req := httptest.NewRequest("GET", "/page.webc", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ct := w.Header().Get("Content-Type"); ct != "text/x-webc; charset=utf-8" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	os.WriteFile(file, []byte("text/plain txt\nbroken ext\n"), 0o644)
+	if _, err := LoadMIMETypes(file); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+	if _, err := LoadMIMETypes(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
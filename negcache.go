@@ -0,0 +1,120 @@
+package anystatic
+
+import (
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// defaultNegativeCacheTTL is used by WithNegativeCache when ttl is not positive.
+const defaultNegativeCacheTTL = 2 * time.Second
+
+// negativeLogInterval is the least time between two logs of the same cached miss.
+const negativeLogInterval = 10 * time.Second
+
+// errNegativeCached is returned by Handler.stat for a path known to be missing.
+var errNegativeCached = fmt.Errorf("%w (cached)", fs.ErrNotExist)
+
+// WithNegativeCache remembers up to maxEntries missing paths for ttl (default 2s),
+// so repeated requests for them (e.g. scans for /wp-login.php) make no filesystem calls
+// and are logged at most once per 10s per path.
+// The cache is cleared when the modification time of the root directory changes.
+func WithNegativeCache(maxEntries int, ttl time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if maxEntries <= 0 {
+			h.negCache = nil
+			return
+		}
+		if ttl <= 0 {
+			ttl = defaultNegativeCacheTTL
+		}
+		h.negCache = &negativeCache{max: maxEntries, ttl: ttl, entries: map[string]*negativeEntry{}}
+	}
+}
+
+type negativeEntry struct {
+	expires    time.Time
+	lastLog    time.Time
+	suppressed int
+}
+
+type negativeCache struct {
+	mu      sync.Mutex
+	max     int
+	ttl     time.Duration
+	entries map[string]*negativeEntry
+
+	rootChecked time.Time
+	rootMod     time.Time
+}
+
+// has reports whether name is a cached miss. An expired entry is kept for its log state
+// until add refreshes it or makes room.
+func (c *negativeCache) has(name string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ent, ok := c.entries[name]
+	return ok && !now.After(ent.expires)
+}
+
+func (c *negativeCache) add(name string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ent, ok := c.entries[name]; ok {
+		ent.expires = now.Add(c.ttl)
+		return
+	}
+	if len(c.entries) >= c.max {
+		for key, ent := range c.entries {
+			if now.After(ent.expires) {
+				delete(c.entries, key)
+			}
+		}
+		for key := range c.entries {
+			if len(c.entries) < c.max {
+				break
+			}
+			delete(c.entries, key)
+		}
+	}
+	c.entries[name] = &negativeEntry{expires: now.Add(c.ttl)}
+}
+
+// logAllowed reports whether a miss of name should be logged now,
+// and how many logs of it were suppressed since the last one.
+func (c *negativeCache) logAllowed(name string, now time.Time) (bool, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ent, ok := c.entries[name]
+	if !ok {
+		return true, 0
+	}
+	if now.Sub(ent.lastLog) < negativeLogInterval {
+		ent.suppressed++
+		return false, 0
+	}
+	suppressed := ent.suppressed
+	ent.lastLog, ent.suppressed = now, 0
+	return true, suppressed
+}
+
+// checkRoot clears the cache when the root modification time changed, checking at most once per ttl.
+func (c *negativeCache) checkRoot(fsys fs.StatFS, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.rootChecked) < c.ttl {
+		return
+	}
+	c.rootChecked = now
+	info, err := fsys.Stat(".")
+	if err != nil {
+		return
+	}
+	if !info.ModTime().Equal(c.rootMod) {
+		if len(c.entries) != 0 {
+			c.entries = map[string]*negativeEntry{}
+		}
+		c.rootMod = info.ModTime()
+	}
+}
@@ -0,0 +1,119 @@
+package anystatic
+
+import (
+	"bytes"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func getStatus(h *Handler, path string) int {
+	req := httptest.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w.Code
+}
+
+// TestNegativeCache_Hit tests a repeated miss makes no Stat call until it expires
+func TestNegativeCache_Hit(t *testing.T) {
+	fsys := &countingFS{MapFS: fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte("index")},
+	}}
+	h := NewHandler(fsys, WithNegativeCache(10, time.Hour))
+
+	if code := getStatus(h, "/wp-login.php"); code != http.StatusNotFound {
+		t.Fatalf("unexpected status %d", code)
+	}
+	stats := fsys.stats.Load()
+	for i := 0; i < 5; i++ {
+		if code := getStatus(h, "/wp-login.php"); code != http.StatusNotFound {
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	if got := fsys.stats.Load() - stats; got != 0 {
+		t.Errorf("expected no Stat for cached misses, got %d", got)
+	}
+
+	// a file created below the root shows up after ttl
+	fsys.MapFS["wp-login.php"] = &fstest.MapFile{Data: []byte("php")}
+	if code := getStatus(h, "/wp-login.php"); code != http.StatusNotFound {
+		t.Errorf("expected cached miss, got %d", code)
+	}
+	h.negCache.entries["wp-login.php"].expires = time.Time{}
+	if code := getStatus(h, "/wp-login.php"); code != http.StatusOK {
+		t.Errorf("expected status %d after ttl, got %d", http.StatusOK, code)
+	}
+}
+
+// TestNegativeCache_RootChange tests the cache is cleared when the root changes
+func TestNegativeCache_RootChange(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		".":          &fstest.MapFile{Mode: fs.ModeDir | 0o755, ModTime: modTime},
+		"index.html": &fstest.MapFile{Data: []byte("index")},
+	}
+	h := NewHandler(fsys, WithNegativeCache(10, time.Hour))
+	getStatus(h, "/new.txt")
+
+	fsys["new.txt"] = &fstest.MapFile{Data: []byte("new")}
+	fsys["."].ModTime = modTime.Add(time.Minute)
+	h.negCache.rootChecked = time.Time{}
+	if code := getStatus(h, "/new.txt"); code != http.StatusOK {
+		t.Errorf("expected status %d after root change, got %d", http.StatusOK, code)
+	}
+}
+
+// TestNegativeCache_Bound tests the number of entries is bounded
+func TestNegativeCache_Bound(t *testing.T) {
+	h := NewHandler(fstest.MapFS{}, WithNegativeCache(3, time.Hour))
+	for _, path := range []string{"/a", "/b", "/c", "/d", "/e"} {
+		getStatus(h, path)
+	}
+	if n := len(h.negCache.entries); n != 3 {
+		t.Errorf("expected 3 entries, got %d", n)
+	}
+}
+
+// TestNegativeCache_LogRate tests cached misses are logged once per interval
+func TestNegativeCache_LogRate(t *testing.T) {
+	c := &negativeCache{max: 10, ttl: time.Hour, entries: map[string]*negativeEntry{}}
+	now := time.Now()
+	c.add("x", now)
+	if ok, _ := c.logAllowed("x", now); !ok {
+		t.Errorf("expected the first miss logged")
+	}
+	for i := 0; i < 3; i++ {
+		if ok, _ := c.logAllowed("x", now.Add(time.Second)); ok {
+			t.Errorf("expected suppressed log")
+		}
+	}
+	ok, suppressed := c.logAllowed("x", now.Add(negativeLogInterval))
+	if !ok || suppressed != 3 {
+		t.Errorf("expected log with 3 suppressed, got %v %d", ok, suppressed)
+	}
+}
+
+// TestNegativeCache_LogRateAcrossTTL tests a path missed across ttl boundaries is logged once per interval
+func TestNegativeCache_LogRateAcrossTTL(t *testing.T) {
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte("index")},
+	}
+	h := NewHandler(fsys, WithNegativeCache(10, 5*time.Millisecond))
+	for i := 0; i < 20; i++ {
+		getStatus(h, "/wp-login.php")
+		time.Sleep(2 * time.Millisecond)
+	}
+	if n := strings.Count(buf.String(), "stat failed"); n != 1 {
+		t.Errorf("expected 1 log in %v, got %d", negativeLogInterval, n)
+	}
+}
@@ -0,0 +1,140 @@
+package anystatic
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// defaultFallthroughCodes are passed to the next handler when WithNext is set.
+var defaultFallthroughCodes = []int{http.StatusNotFound, http.StatusMethodNotAllowed}
+
+// savedHeaderKey is the request context key of the response header as it was before
+// Handler ran (e.g. set by an upstream middleware), restored for the next handler:
+// neither headers of Handler nor those of WithResponseHeaders reach its response.
+type savedHeaderKey struct{}
+
+// WithNext passes requests that would end in a fall-through status (see WithFallthroughCodes)
+// to next instead of answering them.
+func WithNext(next http.Handler) HandlerOption {
+	return func(h *Handler) {
+		h.next = next
+		if h.fallthroughCodes == nil {
+			WithFallthroughCodes(defaultFallthroughCodes...)(h)
+		}
+	}
+}
+
+// WithFallthroughCodes sets the statuses passed to the next handler (default 404 and 405).
+func WithFallthroughCodes(codes ...int) HandlerOption {
+	return func(h *Handler) {
+		h.fallthroughCodes = map[int]bool{}
+		for _, code := range codes {
+			h.fallthroughCodes[code] = true
+		}
+	}
+}
+
+// WithFallthroughPrefixes sets URL path prefixes that are always passed to the next handler.
+func WithFallthroughPrefixes(prefixes ...string) HandlerOption {
+	return func(h *Handler) {
+		h.fallthroughPrefixes = prefixes
+	}
+}
+
+func (h *Handler) isFallthroughPath(path string) bool {
+	for _, prefix := range h.fallthroughPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusWriter records the status written by the next handler for the access log.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush, Hijack and ReadFrom forward to the wrapped writer, so streaming (e.g. server-sent
+// events), protocol upgrades and sendfile keep working behind the wrapper.
+func (w *statusWriter) Flush() {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	if w.code == 0 {
+		w.code = http.StatusSwitchingProtocols
+	}
+	return hj.Hijack()
+}
+
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	// hide ReadFrom from io.Copy to avoid recursion
+	return io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (h *Handler) serveNext(res http.ResponseWriter, req *http.Request) int {
+	if saved, ok := req.Context().Value(savedHeaderKey{}).(http.Header); ok {
+		hdr := res.Header()
+		for key := range hdr {
+			delete(hdr, key)
+		}
+		for key, values := range saved {
+			hdr[key] = values
+		}
+	}
+	sw := &statusWriter{ResponseWriter: res}
+	h.next.ServeHTTP(sw, req)
+	if sw.code == 0 {
+		return http.StatusOK
+	}
+	return sw.code
+}
+
+// fail answers the request with an error status (and its error page, see WithErrorPage),
+// or passes it to the next handler if the status falls through.
+func (h *Handler) fail(res http.ResponseWriter, req *http.Request, code int) int {
+	if h.next != nil && h.fallthroughCodes[code] {
+		slog.Debug("pass to next handler", "path", req.URL.Path, "status", code)
+		return h.serveNext(res, req)
+	}
+	return h.writeError(res, req, code)
+}
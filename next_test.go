@@ -0,0 +1,244 @@
+package anystatic
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func nextHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Next", "1")
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("from next"))
+	})
+}
+
+// TestServeHTTP_NextOnMiss tests a missing file is passed to the next handler
+func TestServeHTTP_NextOnMiss(t *testing.T) {
+	fsys := fstest.MapFS{
+		"static.txt": &fstest.MapFile{Data: []byte("static")},
+	}
+	h := NewHandler(fsys, WithNext(nextHandler()))
+
+	req := httptest.NewRequest("GET", "/missing.txt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTeapot {
+		t.Errorf("expected status %d, got %d", http.StatusTeapot, w.Code)
+	}
+	if body := w.Body.String(); body != "from next" {
+		t.Errorf("expected body from next, got %q", body)
+	}
+	if vary := w.Header().Get("Vary"); vary != "" {
+		t.Errorf("expected no Vary from anystatic, got %q", vary)
+	}
+}
+
+// TestServeHTTP_NextOnHit tests existing files are still served
+func TestServeHTTP_NextOnHit(t *testing.T) {
+	fsys := fstest.MapFS{
+		"static.txt": &fstest.MapFile{Data: []byte("static")},
+	}
+	h := NewHandler(fsys, WithNext(nextHandler()))
+
+	req := httptest.NewRequest("GET", "/static.txt", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if body := w.Body.String(); body != "static" {
+		t.Errorf("expected body 'static', got %q", body)
+	}
+}
+
+// TestServeHTTP_NextPrefix tests configured prefixes always reach the next handler
+func TestServeHTTP_NextPrefix(t *testing.T) {
+	fsys := fstest.MapFS{
+		"api/x.txt": &fstest.MapFile{Data: []byte("shadowed")},
+	}
+	h := NewHandler(fsys, WithNext(nextHandler()), WithFallthroughPrefixes("/api/"))
+
+	req := httptest.NewRequest("GET", "/api/x.txt", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTeapot {
+		t.Errorf("expected status %d, got %d", http.StatusTeapot, w.Code)
+	}
+}
+
+// TestServeHTTP_NextMethod tests non-GET/HEAD methods reach the next handler
+func TestServeHTTP_NextMethod(t *testing.T) {
+	fsys := fstest.MapFS{
+		"static.txt": &fstest.MapFile{Data: []byte("static")},
+	}
+	h := NewHandler(fsys, WithNext(nextHandler()))
+
+	req := httptest.NewRequest("POST", "/static.txt", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTeapot {
+		t.Errorf("expected status %d, got %d", http.StatusTeapot, w.Code)
+	}
+}
+
+// TestServeHTTP_NextCodes tests only the configured statuses fall through
+func TestServeHTTP_NextCodes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"static.txt": &fstest.MapFile{Data: []byte("static")},
+	}
+	h := NewHandler(fsys, WithFallthroughCodes(http.StatusNotAcceptable), WithNext(nextHandler()))
+
+	req := httptest.NewRequest("GET", "/missing.txt", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/static.txt", nil)
+	req.Header.Set("Accept-Encoding", "identity;q=0")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusTeapot {
+		t.Errorf("expected status %d, got %d", http.StatusTeapot, w.Code)
+	}
+}
+
+// TestServeHTTP_NoNext tests fall-through prefixes are ignored without the next handler
+func TestServeHTTP_NoNext(t *testing.T) {
+	fsys := fstest.MapFS{
+		"api/x.txt": &fstest.MapFile{Data: []byte("shadowed")},
+	}
+	h := NewHandler(fsys, WithFallthroughPrefixes("/api/"))
+
+	req := httptest.NewRequest("GET", "/api/x.txt", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+// TestServeHTTP_NextWriterInterfaces tests the next handler can flush, hijack and use ReadFrom
+func TestServeHTTP_NextWriterInterfaces(t *testing.T) {
+	var flusher, hijacker, readerFrom bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flusher = w.(http.Flusher)
+		_, hijacker = w.(http.Hijacker)
+		_, readerFrom = w.(io.ReaderFrom)
+		w.Write([]byte("event: x\n\n"))
+		w.(http.Flusher).Flush()
+	})
+	h := NewHandler(fstest.MapFS{}, WithNext(next))
+
+	req := httptest.NewRequest("GET", "/events", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if !flusher || !hijacker || !readerFrom {
+		t.Errorf("expected Flusher, Hijacker and ReaderFrom, got %v %v %v", flusher, hijacker, readerFrom)
+	}
+	if !w.Flushed || w.Body.String() != "event: x\n\n" {
+		t.Errorf("expected flushed body, got flushed=%v %q", w.Flushed, w.Body.String())
+	}
+
+	// httptest.ResponseRecorder is no Hijacker
+	var hijackErr error
+	h = NewHandler(fstest.MapFS{}, WithNext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, hijackErr = w.(http.Hijacker).Hijack()
+	})))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ws", nil))
+	if hijackErr != http.ErrNotSupported {
+		t.Errorf("expected ErrNotSupported, got %v", hijackErr)
+	}
+}
+
+// TestServeHTTP_NextResponseHeaders tests configured headers are not sent with responses of the next handler
+func TestServeHTTP_NextResponseHeaders(t *testing.T) {
+	fsys := fstest.MapFS{
+		"static.txt": &fstest.MapFile{Data: []byte("static")},
+	}
+	h := NewHandler(fsys, WithNext(nextHandler()), WithResponseHeaders(map[string]string{"Cache-Control": "max-age=31536000, immutable"}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/missing.txt", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("expected status %d, got %d", http.StatusTeapot, w.Code)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "" {
+		t.Errorf("expected no Cache-Control on next response, got %q", cc)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/static.txt", nil))
+	if cc := w.Header().Get("Cache-Control"); cc != "max-age=31536000, immutable" {
+		t.Errorf("expected Cache-Control on file, got %q", cc)
+	}
+
+	// an upstream value is kept on fall-through
+	w = httptest.NewRecorder()
+	w.Header().Set("Cache-Control", "no-store")
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/missing.txt", nil))
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("expected upstream Cache-Control on next response, got %q", cc)
+	}
+}
+
+// TestServeHTTP_NextStrictMIME tests nosniff is not sent with responses of the next handler
+func TestServeHTTP_NextStrictMIME(t *testing.T) {
+	fsys := fstest.MapFS{
+		"static.txt": &fstest.MapFile{Data: []byte("static")},
+	}
+	h := NewHandler(fsys, WithStrictMIME(true), WithFallthroughCodes(http.StatusNotAcceptable), WithNext(nextHandler()))
+
+	req := httptest.NewRequest("GET", "/static.txt", nil)
+	req.Header.Set("Accept-Encoding", "identity;q=0")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusTeapot {
+		t.Errorf("expected status %d, got %d", http.StatusTeapot, w.Code)
+	}
+	if xcto := w.Header().Get("X-Content-Type-Options"); xcto != "" {
+		t.Errorf("expected no X-Content-Type-Options on next response, got %q", xcto)
+	}
+}
+
+// TestServeHTTP_NextUpstreamHeaders tests headers set before the handler ran reach the next handler unchanged
+func TestServeHTTP_NextUpstreamHeaders(t *testing.T) {
+	fsys := fstest.MapFS{
+		"static.txt": &fstest.MapFile{Data: []byte("static")},
+	}
+	h := NewHandler(fsys, WithNext(nextHandler()))
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Origin")
+		w.Header().Set("Cache-Control", "no-store")
+		h.ServeHTTP(w, r)
+	})
+
+	req := httptest.NewRequest("GET", "/missing.txt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	upstream.ServeHTTP(w, req)
+	if w.Code != http.StatusTeapot {
+		t.Errorf("expected status %d, got %d", http.StatusTeapot, w.Code)
+	}
+	if vary := w.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Origin" {
+		t.Errorf("expected Vary: Origin, got %q", vary)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("expected Cache-Control: no-store, got %q", cc)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "" {
+		t.Errorf("expected no Content-Type from anystatic, got %q", ct)
+	}
+}
@@ -3,15 +3,60 @@ package anystatic
 import (
 	"context"
 	"fmt"
-	"io/fs"
 	"log/slog"
 	"net/http"
-	"os"
+	"time"
 )
 
 type Config struct {
-	RootDir          string `json:"rootdir,omitempty"`
-	LogAccessHeaders *bool  `json:"logaccessheaders,omitempty"`
+	RootDir          string            `json:"rootdir,omitempty"`
+	RootDirs         []string          `json:"rootdirs,omitempty"`
+	LogAccessHeaders *bool             `json:"logaccessheaders,omitempty"`
+	Encodings        []string          `json:"encodings,omitempty"`
+	Methods          []string          `json:"methods,omitempty"`
+	SPAFallback      string            `json:"spafallback,omitempty"`
+	ErrorPages       []string          `json:"errorpages,omitempty"`
+	AutoIndex        bool              `json:"autoindex,omitempty"`
+	IndexFiles       []string          `json:"indexfiles,omitempty"`
+	TryFiles         []string          `json:"tryfiles,omitempty"`
+	DenyDotfiles     *bool             `json:"denydotfiles,omitempty"`
+	Deny             []string          `json:"deny,omitempty"`
+	Allow            []string          `json:"allow,omitempty"`
+	DenyStatus       int               `json:"denystatus,omitempty"`
+	Symlinks         string            `json:"symlinks,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"`
+	Prefix           string            `json:"prefix,omitempty"`
+	MetaCache        int               `json:"metacache,omitempty"`
+	MetaCacheTTL     string            `json:"metacachettl,omitempty"`
+	NegativeCache    int               `json:"negativecache,omitempty"`
+	NegativeCacheTTL string            `json:"negativecachettl,omitempty"`
+	FileCache        int64             `json:"filecache,omitempty"`
+	FileCacheMaxFile int64             `json:"filecachemaxfile,omitempty"`
+	// MIMETypesFile is a mime.types file, MIMETypes overrides by extension, see WithMIMETypes
+	MIMETypesFile string            `json:"mimetypesfile,omitempty"`
+	MIMETypes     map[string]string `json:"mimetypes,omitempty"`
+	Charsets      map[string]string `json:"charsets,omitempty"`
+	StrictMIME    bool              `json:"strictmime,omitempty"`
+
+	// PrecompressedOnly serves files deployed only as pre-compressed variants
+	PrecompressedOnly bool `json:"precompressedonly,omitempty"`
+
+	// Compress enables on-the-fly gzip/deflate, see DynamicCompression
+	Compress          bool     `json:"compress,omitempty"`
+	CompressTypes     []string `json:"compresstypes,omitempty"`
+	CompressMinSize   int64    `json:"compressminsize,omitempty"`
+	CompressMaxSize   int64    `json:"compressmaxsize,omitempty"`
+	CompressLevel     int      `json:"compresslevel,omitempty"`
+	CompressWriteBack bool     `json:"compresswriteback,omitempty"`
+
+	// VHostsFile and VHostsDir select a root per Host header, see LoadVHosts
+	VHostsFile string `json:"vhostsfile,omitempty"`
+	VHostsDir  string `json:"vhostsdir,omitempty"`
+
+	// Fallthrough passes misses to the next handler instead of answering 404
+	Fallthrough         bool     `json:"fallthrough,omitempty"`
+	FallthroughCodes    []int    `json:"fallthroughcodes,omitempty"`
+	FallthroughPrefixes []string `json:"fallthroughprefixes,omitempty"`
 }
 
 func CreateConfig() *Config {
@@ -24,17 +69,185 @@ type AnyStatic struct {
 	name string
 }
 
-func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	if config.RootDir == "" {
-		return nil, fmt.Errorf("rootdir cannot be empty")
+// rootDirs returns RootDir followed by RootDirs.
+func (config *Config) rootDirs() []string {
+	roots := config.RootDirs
+	if config.RootDir != "" {
+		roots = append([]string{config.RootDir}, roots...)
 	}
-	slog.Info("anystatic plugin initialized", "rootdir", config.RootDir)
-	fs := os.DirFS(config.RootDir).(fs.StatFS)
+	return roots
+}
+
+// handlerOptions converts config to handler options. Fallthrough needs next and is ignored without it.
+func (config *Config) handlerOptions(next http.Handler) ([]HandlerOption, error) {
 	opts := []HandlerOption{}
 	if config.LogAccessHeaders != nil {
 		opts = append(opts, WithAccessLogHeaders(*config.LogAccessHeaders))
 	}
-	hdl := NewHandler(fs, opts...)
+	if len(config.Encodings) != 0 {
+		encs, err := ParseEncodings(config.Encodings)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithEncodings(encs...))
+	}
+	if len(config.Methods) != 0 {
+		opts = append(opts, WithMethods(config.Methods...))
+	}
+	if config.SPAFallback != "" {
+		opts = append(opts, WithSPAFallback(config.SPAFallback))
+	}
+	if len(config.IndexFiles) != 0 {
+		opts = append(opts, WithIndexFiles(config.IndexFiles...))
+	}
+	if len(config.TryFiles) != 0 {
+		opts = append(opts, WithTryFiles(config.TryFiles...))
+	}
+	if config.DenyDotfiles != nil {
+		opts = append(opts, WithDenyDotfiles(*config.DenyDotfiles))
+	}
+	if len(config.Deny) != 0 {
+		rules, err := ParsePathRules(config.Deny)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithDeny(rules...))
+	}
+	if len(config.Allow) != 0 {
+		rules, err := ParsePathRules(config.Allow)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithAllow(rules...))
+	}
+	if config.DenyStatus != 0 {
+		if err := CheckDenyStatus(config.DenyStatus); err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithDenyStatus(config.DenyStatus))
+	}
+	if config.AutoIndex {
+		opts = append(opts, WithAutoIndex(true))
+	}
+	if config.MetaCache > 0 {
+		var ttl time.Duration
+		if config.MetaCacheTTL != "" {
+			var err error
+			if ttl, err = time.ParseDuration(config.MetaCacheTTL); err != nil {
+				return nil, err
+			}
+		}
+		opts = append(opts, WithMetaCache(config.MetaCache, ttl))
+	}
+	if config.NegativeCache > 0 {
+		var ttl time.Duration
+		if config.NegativeCacheTTL != "" {
+			var err error
+			if ttl, err = time.ParseDuration(config.NegativeCacheTTL); err != nil {
+				return nil, err
+			}
+		}
+		opts = append(opts, WithNegativeCache(config.NegativeCache, ttl))
+	}
+	if config.FileCache > 0 {
+		maxFile := config.FileCacheMaxFile
+		if maxFile <= 0 {
+			maxFile = defaultFileCacheMaxFile
+		}
+		opts = append(opts, WithFileCache(config.FileCache, maxFile))
+	}
+	if config.MIMETypesFile != "" {
+		types, err := LoadMIMETypes(config.MIMETypesFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithMIMETypes(types))
+	}
+	if len(config.MIMETypes) != 0 {
+		opts = append(opts, WithMIMETypes(config.MIMETypes))
+	}
+	if len(config.Charsets) != 0 {
+		opts = append(opts, WithCharsets(config.Charsets))
+	}
+	if config.StrictMIME {
+		opts = append(opts, WithStrictMIME(true))
+	}
+	if config.PrecompressedOnly {
+		opts = append(opts, WithPrecompressedOnly(true))
+	}
+	if config.Compress {
+		opts = append(opts, WithDynamicCompression(DynamicCompression{
+			Types:     config.CompressTypes,
+			MinSize:   config.CompressMinSize,
+			MaxSize:   config.CompressMaxSize,
+			Level:     config.CompressLevel,
+			WriteBack: config.CompressWriteBack,
+		}))
+	}
+	if config.Prefix != "" {
+		opts = append(opts, WithPrefix(config.Prefix))
+	}
+	if len(config.Headers) != 0 {
+		opts = append(opts, WithResponseHeaders(config.Headers))
+	}
+	for _, spec := range config.ErrorPages {
+		codes, document, err := ParseErrorPage(spec)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithErrorPage(document, codes...))
+	}
+	if config.Fallthrough && next != nil {
+		slog.Info("fallthrough enabled", "codes", config.FallthroughCodes, "prefixes", config.FallthroughPrefixes)
+		opts = append(opts, WithNext(next))
+		if len(config.FallthroughCodes) != 0 {
+			opts = append(opts, WithFallthroughCodes(config.FallthroughCodes...))
+		}
+		if len(config.FallthroughPrefixes) != 0 {
+			opts = append(opts, WithFallthroughPrefixes(config.FallthroughPrefixes...))
+		}
+	}
+	return opts, nil
+}
+
+func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	roots := config.rootDirs()
+	vhosts := config.VHostsFile != "" || config.VHostsDir != ""
+	if len(roots) == 0 && !vhosts {
+		return nil, fmt.Errorf("rootdir cannot be empty")
+	}
+	slog.Info("anystatic plugin initialized", "rootdir", roots, "vhostsfile", config.VHostsFile, "vhostsdir", config.VHostsDir)
+	policy, err := ParseSymlinkPolicy(config.Symlinks)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := config.handlerOptions(next)
+	if err != nil {
+		return nil, err
+	}
+	var hdl http.Handler
+	if vhosts {
+		vh, err := LoadVHosts(config.VHostsFile, config.VHostsDir, policy, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if vh.Default() == nil && len(roots) != 0 {
+			fs, err := OpenRoots(roots, policy)
+			if err != nil {
+				return nil, err
+			}
+			vh.Add("default", NewHandler(fs, joinOptions(opts, WithAccessLogField("vhost", "default"))...))
+		} else if vh.Default() == nil && config.Fallthrough {
+			vh.Add("default", next)
+		}
+		hdl = vh
+	} else {
+		fs, err := OpenRoots(roots, policy)
+		if err != nil {
+			return nil, err
+		}
+		hdl = NewHandler(fs, opts...)
+	}
 
 	return &AnyStatic{
 		next: next,
@@ -0,0 +1,140 @@
+package anystatic
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	pathpkg "path"
+)
+
+// maxDecompressSize bounds the output of decompressing a gzip variant for a client that
+// does not accept it; larger files get 406.
+const maxDecompressSize = 64 << 20
+
+// WithPrecompressedOnly serves a file whose original is missing when a pre-compressed
+// variant exists (e.g. only app.js.br and app.js.gz are deployed). The content type is
+// taken from the name without the variant suffix. A client accepting none of the stored
+// encodings gets the gzip variant decompressed in memory, or 406 when there is none.
+func WithPrecompressedOnly(enabled bool) HandlerOption {
+	return func(h *Handler) {
+		h.precompressedOnly = enabled
+	}
+}
+
+// absentInfo stands for a missing original whose variants exist. It is the file info of
+// the gzip variant (or of the first variant found) under the name of the original,
+// and vfs is the layer all variants are taken from.
+type absentInfo struct {
+	fs.FileInfo
+	name string
+	vfs  fs.StatFS
+}
+
+func (i *absentInfo) Name() string { return i.name }
+
+// isAbsent reports whether info stands for a missing original.
+func isAbsent(info fs.FileInfo) bool {
+	_, ok := info.(*absentInfo)
+	return ok
+}
+
+// statVariants returns an absentInfo for name when one of its variants is a regular file, or nil.
+// With a layered filesystem, the first layer having a variant holds them all, so a stale
+// variant of a lower layer never mixes with those of an upper one.
+func (h *Handler) statVariants(name string) fs.FileInfo {
+	layers := []fs.StatFS{h.fs}
+	if lfs, ok := h.fs.(layeredFS); ok {
+		layers = lfs.Layers()
+	}
+	for _, layer := range layers {
+		found, err := h.statVariantsIn(layer, name)
+		if err != nil {
+			// e.g. a refused symlink: do not look below
+			return nil
+		}
+		if found != nil {
+			return &absentInfo{FileInfo: found, name: pathpkg.Base(name), vfs: layer}
+		}
+	}
+	return nil
+}
+
+// statVariantsIn returns the file info of the gzip variant of name in fsys, or of the first
+// variant found, or nil. Errors other than not-exist are returned.
+func (h *Handler) statVariantsIn(fsys fs.StatFS, name string) (fs.FileInfo, error) {
+	var found fs.FileInfo
+	for _, ei := range h.encodings {
+		vinfo, err := fsys.Stat(name + ei.ext)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			continue
+		}
+		if vinfo.IsDir() {
+			continue
+		}
+		if ei.encode == "gzip" {
+			return vinfo, nil
+		}
+		if found == nil {
+			found = vinfo
+		}
+	}
+	return found, nil
+}
+
+// decompressed returns the identity bytes of path, whose original is missing, from its gzip variant.
+func (h *Handler) decompressed(meta *fileMeta, path string) ([]byte, bool) {
+	var gzInfo fs.FileInfo
+	ext := ""
+	for _, ei := range h.encodings {
+		if ei.encode == "gzip" {
+			ext, gzInfo = ei.ext, meta.variant(ei.ext)
+			break
+		}
+	}
+	if gzInfo == nil {
+		return nil, false
+	}
+	// "\x00" never appears in a file name, so the output does not collide with a real file
+	key := path + "\x00identity"
+	if h.fileCache != nil {
+		if data, ok := h.fileCache.get(key, gzInfo); ok {
+			return data, true
+		}
+	}
+	data, err := gunzipFile(meta.vfs, path+ext)
+	if err != nil {
+		slog.Error("decompress failed", "path", path+ext, "error", err)
+		return nil, false
+	}
+	slog.Debug("decompressed", "path", path+ext, "encoded", gzInfo.Size(), "original", len(data))
+	if h.fileCache != nil && int64(len(data)) <= h.fileCache.maxFileSize {
+		h.fileCache.put(key, gzInfo, data)
+	}
+	return data, true
+}
+
+func gunzipFile(fsys fs.FS, name string) ([]byte, error) {
+	fp, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	zr, err := gzip.NewReader(fp)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(zr, maxDecompressSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDecompressSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxDecompressSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,160 @@
+package anystatic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestPrecompressedOnly tests variants are served when the original is missing
+func TestPrecompressedOnly(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.js.br":          &fstest.MapFile{Data: []byte("br-data")},
+		"app.js.gz":          &fstest.MapFile{Data: gzipBytes(t, "console.log(1)")},
+		"only.css.br":        &fstest.MapFile{Data: []byte("br-only")},
+		"docs/index.html.gz": &fstest.MapFile{Data: gzipBytes(t, "<html>docs</html>")},
+	}
+	h := NewHandler(fsys, WithPrecompressedOnly(true))
+
+	testCases := []struct {
+		path     string
+		accept   string
+		status   int
+		ctype    string
+		encoding string
+		body     string
+	}{
+		{"/app.js", "br, gzip", http.StatusOK, "text/javascript; charset=utf-8", "br", "br-data"},
+		{"/app.js", "gzip", http.StatusOK, "text/javascript; charset=utf-8", "gzip", ""},
+		{"/app.js", "", http.StatusOK, "text/javascript; charset=utf-8", "", "console.log(1)"},
+		{"/app.js", "zstd", http.StatusOK, "text/javascript; charset=utf-8", "", "console.log(1)"},
+		{"/app.js", "zstd, identity;q=0", http.StatusNotAcceptable, "", "", ""},
+		{"/only.css", "br", http.StatusOK, "text/css; charset=utf-8", "br", "br-only"},
+		{"/only.css", "gzip", http.StatusNotAcceptable, "", "", ""},
+		{"/docs/", "", http.StatusOK, "text/html; charset=utf-8", "", "<html>docs</html>"},
+		{"/missing.js", "gzip", http.StatusNotFound, "", "", ""},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept-Encoding", tc.accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s %q: expected status %d, got %d", tc.path, tc.accept, tc.status, w.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != tc.ctype {
+			t.Errorf("%s %q: expected content type %q, got %q", tc.path, tc.accept, tc.ctype, ct)
+		}
+		if ce := w.Header().Get("Content-Encoding"); ce != tc.encoding {
+			t.Errorf("%s %q: expected encoding %q, got %q", tc.path, tc.accept, tc.encoding, ce)
+		}
+		if tc.body != "" && w.Body.String() != tc.body {
+			t.Errorf("%s %q: expected body %q, got %q", tc.path, tc.accept, tc.body, w.Body.String())
+		}
+	}
+}
+
+// TestPrecompressedOnly_Head tests HEAD of a decompressed file has its length
+func TestPrecompressedOnly_Head(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.js.gz": &fstest.MapFile{Data: gzipBytes(t, "console.log(1)")},
+	}
+	h := NewHandler(fsys, WithPrecompressedOnly(true), WithFileCache(1024, 1024))
+	req := httptest.NewRequest("HEAD", "/app.js", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Length") != "14" || w.Body.Len() != 0 {
+		t.Errorf("unexpected HEAD response %d, length %q", w.Code, w.Header().Get("Content-Length"))
+	}
+	etag := w.Header().Get("ETag")
+	req = httptest.NewRequest("GET", "/app.js", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("ETag") != etag || w.Body.String() != "console.log(1)" {
+		t.Errorf("unexpected GET response etag %s body %q", w.Header().Get("ETag"), w.Body.String())
+	}
+}
+
+// TestPrecompressedOnly_Disabled tests a missing original is 404 by default
+func TestPrecompressedOnly_Disabled(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.js.gz": &fstest.MapFile{Data: gzipBytes(t, "console.log(1)")},
+	}
+	h := NewHandler(fsys)
+	req := httptest.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// wrappedUnion is a layered filesystem other than *UnionFS
+type wrappedUnion struct {
+	*UnionFS
+}
+
+// TestPrecompressedOnly_Union tests variants of a missing original are taken from a single layer
+func TestPrecompressedOnly_Union(t *testing.T) {
+	upper := fstest.MapFS{
+		"app.js.br": &fstest.MapFile{Data: []byte("br-upper")},
+	}
+	lower := fstest.MapFS{
+		"app.js.gz":  &fstest.MapFile{Data: gzipBytes(t, "old-gz")},
+		"lib.js.gz":  &fstest.MapFile{Data: gzipBytes(t, "lib-gz")},
+		"lib.js.zst": &fstest.MapFile{Data: []byte("lib-zst")},
+	}
+
+	testCases := []struct {
+		path   string
+		accept string
+		status int
+		body   string
+	}{
+		{"/app.js", "br", http.StatusOK, "br-upper"},
+		{"/app.js", "gzip", http.StatusNotAcceptable, ""},
+		{"/app.js", "", http.StatusNotAcceptable, ""},
+		{"/lib.js", "", http.StatusOK, "lib-gz"},
+	}
+	// any layered filesystem, not only *UnionFS
+	for _, fsys := range []fs.StatFS{NewUnionFS(upper, lower), wrappedUnion{NewUnionFS(upper, lower)}} {
+		h := NewHandler(fsys, WithPrecompressedOnly(true))
+		for _, tc := range testCases {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept-Encoding", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.status {
+				t.Errorf("%T %s %q: expected status %d, got %d", fsys, tc.path, tc.accept, tc.status, w.Code)
+				continue
+			}
+			if tc.body != "" && w.Body.String() != tc.body {
+				t.Errorf("%T %s %q: expected body %q, got %q", fsys, tc.path, tc.accept, tc.body, w.Body.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package anystatic
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidRange = errors.New("invalid range")
+	errNoOverlap    = errors.New("range does not overlap the content")
+	errNotSeekable  = errors.New("file is not seekable")
+)
+
+// maxRangesPerSpec limits the number of ranges in one Range header.
+const maxRangesPerSpec = 64
+
+// httpRange is a byte range of the selected representation.
+// When Content-Encoding is set, offsets refer to the encoded bytes.
+type httpRange struct {
+	start, length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(r.start, 10) + "-" + strconv.FormatInt(r.start+r.length-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
+
+func (r httpRange) mimeHeader(ctype string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {r.contentRange(size)},
+		"Content-Type":  {ctype},
+	}
+}
+
+// parseRange parses a Range header value (RFC 9110 14.1.2).
+// errInvalidRange means the header should be ignored,
+// errNoOverlap means no range is satisfiable and 416 should be returned.
+func parseRange(s string, size int64) ([]httpRange, error) {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil, errInvalidRange
+	}
+	specs := strings.Split(s[len(b):], ",")
+	if len(specs) > maxRangesPerSpec {
+		return nil, errInvalidRange
+	}
+	var ranges []httpRange
+	noOverlap := false
+	for _, ra := range specs {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		startStr, endStr, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		startStr, endStr = strings.TrimSpace(startStr), strings.TrimSpace(endStr)
+		var r httpRange
+		if startStr == "" {
+			// suffix-range: -N means the last N bytes
+			if endStr == "" || endStr[0] == '-' {
+				return nil, errInvalidRange
+			}
+			n, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil {
+				return nil, errInvalidRange
+			}
+			if n == 0 || size == 0 {
+				noOverlap = true
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r.start = size - n
+			r.length = n
+		} else {
+			start, err := strconv.ParseInt(startStr, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			if start >= size {
+				noOverlap = true
+				continue
+			}
+			r.start = start
+			if endStr == "" {
+				r.length = size - start
+			} else {
+				end, err := strconv.ParseInt(endStr, 10, 64)
+				if err != nil || start > end {
+					return nil, errInvalidRange
+				}
+				if end >= size {
+					end = size - 1
+				}
+				r.length = end - start + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		if noOverlap {
+			return nil, errNoOverlap
+		}
+		return nil, errInvalidRange
+	}
+	return ranges, nil
+}
+
+// ifRangeMatch reports whether the Range header should be honored.
+// An If-Range entity-tag needs a strong match, a date needs an exact match.
+func ifRangeMatch(req *http.Request, etag string, modtime time.Time) bool {
+	ir := req.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if tag, weak, _ := scanETag(ir); tag != "" {
+		return !weak && tag == etag
+	}
+	t, err := http.ParseTime(ir)
+	if err != nil {
+		return false
+	}
+	return t.Equal(modtime.Truncate(time.Second))
+}
+
+func rangeReader(fp fs.File, r httpRange) (io.Reader, error) {
+	// prefer Seek+LimitReader: it keeps *os.File visible to http.ResponseWriter's ReadFrom
+	if sk, ok := fp.(io.Seeker); ok {
+		if _, err := sk.Seek(r.start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return io.LimitReader(fp, r.length), nil
+	}
+	if ra, ok := fp.(io.ReaderAt); ok {
+		return io.NewSectionReader(ra, r.start, r.length), nil
+	}
+	return nil, errNotSeekable
+}
+
+func isSeekable(fp fs.File) bool {
+	if _, ok := fp.(io.Seeker); ok {
+		return true
+	}
+	_, ok := fp.(io.ReaderAt)
+	return ok
+}
+
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+func rangesMIMESize(ranges []httpRange, ctype string, size int64) int64 {
+	var w countingWriter
+	var encSize int64
+	mw := multipart.NewWriter(&w)
+	for _, ra := range ranges {
+		mw.CreatePart(ra.mimeHeader(ctype, size))
+		encSize += ra.length
+	}
+	mw.Close()
+	return int64(w) + encSize
+}
+
+// serveRange writes a 206 (or 416) response for spec.
+// ok is false when the Range header should be ignored and the full body sent instead.
+func (h *Handler) serveRange(res http.ResponseWriter, fp fs.File, path string, spec string, size int64) (code int, ok bool) {
+	if !isSeekable(fp) {
+		slog.Debug("range ignored, file is not seekable", "path", path)
+		return http.StatusOK, false
+	}
+	ranges, err := parseRange(spec, size)
+	if err == errNoOverlap {
+		res.Header().Del("Content-Type")
+		res.Header().Del("Content-Encoding")
+		res.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+		res.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return http.StatusRequestedRangeNotSatisfiable, true
+	}
+	if err != nil {
+		slog.Debug("range ignored", "path", path, "range", spec, "error", err)
+		return http.StatusOK, false
+	}
+	var total int64
+	for _, ra := range ranges {
+		total += ra.length
+	}
+	if total > size {
+		// overlapping ranges asking for more than the whole file: just send it
+		slog.Debug("range ignored, larger than content", "path", path, "range", spec)
+		return http.StatusOK, false
+	}
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		body, err := rangeReader(fp, ra)
+		if err != nil {
+			slog.Error("seek error", "path", path, "error", err)
+			return http.StatusOK, false
+		}
+		res.Header().Set("Content-Range", ra.contentRange(size))
+		res.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		res.WriteHeader(http.StatusPartialContent)
+		if _, err := io.Copy(res, body); err != nil {
+			slog.Error("copy error", "path", path, "error", err)
+		}
+		return http.StatusPartialContent, true
+	}
+	ctype := res.Header().Get("Content-Type")
+	res.Header().Set("Content-Length", strconv.FormatInt(rangesMIMESize(ranges, ctype, size), 10))
+	mw := multipart.NewWriter(res)
+	res.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	res.WriteHeader(http.StatusPartialContent)
+	for _, ra := range ranges {
+		part, err := mw.CreatePart(ra.mimeHeader(ctype, size))
+		if err != nil {
+			slog.Error("write part error", "path", path, "error", err)
+			return http.StatusPartialContent, true
+		}
+		body, err := rangeReader(fp, ra)
+		if err != nil {
+			slog.Error("seek error", "path", path, "error", err)
+			return http.StatusPartialContent, true
+		}
+		if _, err := io.Copy(part, body); err != nil {
+			slog.Error("copy error", "path", path, "error", err)
+			return http.StatusPartialContent, true
+		}
+	}
+	mw.Close()
+	return http.StatusPartialContent, true
+}
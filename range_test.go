@@ -0,0 +1,249 @@
+package anystatic
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// TestParseRange tests Range header parsing
+func TestParseRange(t *testing.T) {
+	testCases := []struct {
+		spec   string
+		size   int64
+		expect []httpRange
+		err    error
+	}{
+		{"bytes=0-4", 20, []httpRange{{0, 5}}, nil},
+		{"bytes=5-", 20, []httpRange{{5, 15}}, nil},
+		{"bytes=-3", 20, []httpRange{{17, 3}}, nil},
+		{"bytes=-30", 20, []httpRange{{0, 20}}, nil},
+		{"bytes=10-100", 20, []httpRange{{10, 10}}, nil},
+		{"bytes=0-1, 4-5", 20, []httpRange{{0, 2}, {4, 2}}, nil},
+		{"bytes=0-1, 30-40", 20, []httpRange{{0, 2}}, nil},
+		{"bytes=30-40", 20, nil, errNoOverlap},
+		{"bytes=-0", 20, nil, errNoOverlap},
+		{"bytes=5-1", 20, nil, errInvalidRange},
+		{"bytes=x-1", 20, nil, errInvalidRange},
+		{"items=0-1", 20, nil, errInvalidRange},
+		{"bytes=", 20, nil, errInvalidRange},
+	}
+	for _, tc := range testCases {
+		got, err := parseRange(tc.spec, tc.size)
+		if err != tc.err {
+			t.Errorf("parseRange(%q): expected error %v, got %v", tc.spec, tc.err, err)
+			continue
+		}
+		if len(got) != len(tc.expect) {
+			t.Errorf("parseRange(%q): expected %v, got %v", tc.spec, tc.expect, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.expect[i] {
+				t.Errorf("parseRange(%q)[%d]: expected %v, got %v", tc.spec, i, tc.expect[i], got[i])
+			}
+		}
+	}
+}
+
+// TestServeHTTP_AcceptRanges tests Accept-Ranges header on full responses
+func TestServeHTTP_AcceptRanges(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data.txt": &fstest.MapFile{Data: []byte("0123456789abcdefghij")},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/data.txt", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if ar := w.Header().Get("Accept-Ranges"); ar != "bytes" {
+		t.Errorf("expected Accept-Ranges: bytes, got %q", ar)
+	}
+}
+
+// TestServeHTTP_SingleRange tests 206 with a single byte range
+func TestServeHTTP_SingleRange(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data.txt": &fstest.MapFile{Data: []byte("0123456789abcdefghij")},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/data.txt", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("expected status %d, got %d", http.StatusPartialContent, w.Code)
+	}
+	if body := w.Body.String(); body != "2345" {
+		t.Errorf("expected body '2345', got %q", body)
+	}
+	if cr := w.Header().Get("Content-Range"); cr != "bytes 2-5/20" {
+		t.Errorf("unexpected Content-Range: %q", cr)
+	}
+	if cl := w.Header().Get("Content-Length"); cl != "4" {
+		t.Errorf("expected Content-Length: 4, got %q", cl)
+	}
+}
+
+// TestServeHTTP_RangeOverEncoded tests range offsets refer to the encoded bytes
+func TestServeHTTP_RangeOverEncoded(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data.txt":    &fstest.MapFile{Data: []byte("0123456789abcdefghij")},
+		"data.txt.gz": &fstest.MapFile{Data: []byte("GZIPPEDDATA")},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/data.txt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=-4")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("expected status %d, got %d", http.StatusPartialContent, w.Code)
+	}
+	if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Errorf("expected Content-Encoding: gzip, got %q", enc)
+	}
+	if body := w.Body.String(); body != "DATA" {
+		t.Errorf("expected body 'DATA', got %q", body)
+	}
+	if cr := w.Header().Get("Content-Range"); cr != "bytes 7-10/11" {
+		t.Errorf("unexpected Content-Range: %q", cr)
+	}
+}
+
+// TestServeHTTP_MultipartRange tests multipart/byteranges responses
+func TestServeHTTP_MultipartRange(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data.txt": &fstest.MapFile{Data: []byte("0123456789abcdefghij")},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/data.txt", nil)
+	req.Header.Set("Range", "bytes=0-1,10-12")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("expected status %d, got %d", http.StatusPartialContent, w.Code)
+	}
+	if cl := w.Header().Get("Content-Length"); cl != strconv.Itoa(w.Body.Len()) {
+		t.Errorf("Content-Length %q does not match body length %d", cl, w.Body.Len())
+	}
+	mt, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mt != "multipart/byteranges" {
+		t.Fatalf("unexpected Content-Type: %q (%v)", w.Header().Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	expected := []struct{ body, crange string }{
+		{"01", "bytes 0-1/20"},
+		{"abc", "bytes 10-12/20"},
+	}
+	for i, exp := range expected {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		data, _ := io.ReadAll(part)
+		if string(data) != exp.body {
+			t.Errorf("part %d: expected body %q, got %q", i, exp.body, data)
+		}
+		if cr := part.Header.Get("Content-Range"); cr != exp.crange {
+			t.Errorf("part %d: expected Content-Range %q, got %q", i, exp.crange, cr)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("expected end of multipart, got %v", err)
+	}
+}
+
+// TestServeHTTP_RangeNotSatisfiable tests 416 for ranges beyond the end
+func TestServeHTTP_RangeNotSatisfiable(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data.txt": &fstest.MapFile{Data: []byte("0123456789abcdefghij")},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/data.txt", nil)
+	req.Header.Set("Range", "bytes=100-200")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("expected status %d, got %d", http.StatusRequestedRangeNotSatisfiable, w.Code)
+	}
+	if cr := w.Header().Get("Content-Range"); cr != "bytes */20" {
+		t.Errorf("unexpected Content-Range: %q", cr)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("expected empty body, got %q", w.Body.String())
+	}
+}
+
+// TestServeHTTP_InvalidRangeIgnored tests malformed Range headers are ignored
+func TestServeHTTP_InvalidRangeIgnored(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data.txt": &fstest.MapFile{Data: []byte("0123456789abcdefghij")},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/data.txt", nil)
+	req.Header.Set("Range", "bytes=abc")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if body := w.Body.String(); body != "0123456789abcdefghij" {
+		t.Errorf("expected full body, got %q", body)
+	}
+}
+
+// TestServeHTTP_IfRange tests If-Range with entity-tags and dates
+func TestServeHTTP_IfRange(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data.txt": &fstest.MapFile{Data: []byte("0123456789abcdefghij"), ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	h := NewHandler(fsys)
+
+	req := httptest.NewRequest("GET", "/data.txt", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	etag := w.Header().Get("ETag")
+	lastmod := w.Header().Get("Last-Modified")
+
+	testCases := []struct {
+		name    string
+		ifRange string
+		expect  int
+	}{
+		{"matching etag", etag, http.StatusPartialContent},
+		{"weak etag", "W/" + etag, http.StatusOK},
+		{"other etag", `"other"`, http.StatusOK},
+		{"matching date", lastmod, http.StatusPartialContent},
+		{"other date", "Mon, 01 Jan 2024 00:00:00 GMT", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/data.txt", nil)
+			req.Header.Set("Range", "bytes=0-0")
+			req.Header.Set("If-Range", tc.ifRange)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tc.expect {
+				t.Errorf("expected status %d, got %d", tc.expect, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package anystatic
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// layeredFS is implemented by filesystems made of layers, such as UnionFS.
+// serveFile takes pre-compressed variants from the layer holding the original,
+// or for a missing original from the first layer holding any of them.
+type layeredFS interface {
+	LayerOf(name string) (fs.StatFS, error)
+	Layers() []fs.StatFS
+}
+
+// UnionFS overlays several filesystems. Lookups go through the layers in order
+// and the first layer having the name wins; directory listings are merged.
+type UnionFS struct {
+	layers []fs.StatFS
+}
+
+// NewUnionFS returns a union of layers, the first one on top.
+func NewUnionFS(layers ...fs.StatFS) *UnionFS {
+	return &UnionFS{layers: layers}
+}
+
+func (u *UnionFS) String() string {
+	names := make([]string, len(u.layers))
+	for i, l := range u.layers {
+		if s, ok := l.(interface{ String() string }); ok {
+			names[i] = s.String()
+		} else {
+			names[i] = "fs"
+		}
+	}
+	return strings.Join(names, ":")
+}
+
+// Layers returns the layers, the top one first.
+func (u *UnionFS) Layers() []fs.StatFS {
+	return u.layers
+}
+
+// LayerOf returns the layer that name is served from.
+// Errors other than not-exist (e.g. a refused symlink) stop the search.
+func (u *UnionFS) LayerOf(name string) (fs.StatFS, error) {
+	_, layer, err := u.find("stat", name)
+	return layer, err
+}
+
+func (u *UnionFS) find(op, name string) (fs.FileInfo, fs.StatFS, error) {
+	for _, layer := range u.layers {
+		info, err := layer.Stat(name)
+		if err == nil {
+			return info, layer, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+func (u *UnionFS) Stat(name string) (fs.FileInfo, error) {
+	info, _, err := u.find("stat", name)
+	return info, err
+}
+
+func (u *UnionFS) Open(name string) (fs.File, error) {
+	info, layer, err := u.find("open", name)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := layer.Open(name)
+	if err != nil || !info.IsDir() {
+		return fp, err
+	}
+	ents, err := u.ReadDir(name)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return &unionDir{File: fp, entries: ents}, nil
+}
+
+// ReadDir merges the listings of every layer where name is a directory.
+// An entry of an upper layer hides the same name in lower layers.
+func (u *UnionFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var res []fs.DirEntry
+	seen := map[string]bool{}
+	found := false
+	for _, layer := range u.layers {
+		ents, err := fs.ReadDir(layer, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if found {
+				// a file in a lower layer under a merged directory name
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		for _, ent := range ents {
+			if !seen[ent.Name()] {
+				seen[ent.Name()] = true
+				res = append(res, ent)
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name() < res[j].Name() })
+	return res, nil
+}
+
+// unionDir is an open directory listing the merged entries.
+type unionDir struct {
+	fs.File
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *unionDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	list := d.entries[d.offset:]
+	if count > 0 && len(list) == 0 {
+		return nil, io.EOF
+	}
+	if count > 0 && len(list) > count {
+		list = list[:count]
+	}
+	d.offset += len(list)
+	return list, nil
+}
@@ -0,0 +1,85 @@
+package anystatic
+
+import (
+	"io/fs"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// TestUnionFS tests first-match-wins lookup and merged listings
+func TestUnionFS(t *testing.T) {
+	tenant := fstest.MapFS{
+		"style.css": &fstest.MapFile{Data: []byte("tenant style")},
+	}
+	generated := fstest.MapFS{
+		"index.html":    &fstest.MapFile{Data: []byte("generated index")},
+		"docs/new.html": &fstest.MapFile{Data: []byte("new doc")},
+	}
+	theme := fstest.MapFS{
+		"index.html":    &fstest.MapFile{Data: []byte("theme index")},
+		"style.css":     &fstest.MapFile{Data: []byte("theme style")},
+		"docs/old.html": &fstest.MapFile{Data: []byte("old doc")},
+		"logo.svg":      &fstest.MapFile{Data: []byte("<svg/>")},
+	}
+	u := NewUnionFS(tenant, generated, theme)
+	if err := fstest.TestFS(u, "style.css", "index.html", "docs/new.html", "docs/old.html", "logo.svg"); err != nil {
+		t.Error(err)
+	}
+	for name, expect := range map[string]string{"index.html": "generated index", "logo.svg": "<svg/>", "docs/old.html": "old doc"} {
+		data, err := fs.ReadFile(u, name)
+		if err != nil || string(data) != expect {
+			t.Errorf("%s: expected %q, got %q (%v)", name, expect, data, err)
+		}
+	}
+	ents, err := fs.ReadDir(u, "docs")
+	if err != nil || len(ents) != 2 {
+		t.Errorf("expected 2 merged entries, got %v (%v)", ents, err)
+	}
+	if _, err := u.Stat("missing"); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+// TestServeHTTP_UnionVariantLayer tests a compressed file in a lower layer never shadows an upper original
+func TestServeHTTP_UnionVariantLayer(t *testing.T) {
+	old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tenant := fstest.MapFS{
+		"style.css": &fstest.MapFile{Data: []byte(strings.Repeat("tenant ", 20)), ModTime: old.Add(time.Hour)},
+	}
+	theme := fstest.MapFS{
+		"style.css":    &fstest.MapFile{Data: []byte(strings.Repeat("theme ", 20)), ModTime: old},
+		"style.css.gz": &fstest.MapFile{Data: []byte("stale"), ModTime: old.Add(2 * time.Hour)},
+	}
+	h := NewHandler(NewUnionFS(tenant, theme), WithAutoIndex(true))
+
+	req := httptest.NewRequest("GET", "/style.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ce := w.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("unexpected encoding %q", ce)
+	}
+	if !strings.HasPrefix(w.Body.String(), "tenant") {
+		t.Errorf("expected tenant style, got %q", w.Body.String())
+	}
+
+	entries, err := h.readDirIndex("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ent := range entries {
+		if ent.Name == "style.css" && len(ent.Encodings) != 0 {
+			t.Errorf("unexpected encodings %v", ent.Encodings)
+		}
+	}
+
+	h = NewHandler(NewUnionFS(theme))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if ce := w.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Errorf("expected gzip from the same layer, got %q", ce)
+	}
+}
@@ -0,0 +1,192 @@
+package anystatic
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// VHosts dispatches requests to a handler chosen by the Host header.
+type VHosts struct {
+	exact     map[string]http.Handler
+	wildcards []vhostWildcard
+	def       http.Handler
+}
+
+type vhostWildcard struct {
+	suffix  string // ".example.com"
+	handler http.Handler
+}
+
+// NewVHosts returns an empty set of virtual hosts.
+func NewVHosts() *VHosts {
+	return &VHosts{exact: map[string]http.Handler{}}
+}
+
+// normalizeHost lowercases host and removes the port and a trailing dot.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+}
+
+// Add registers h for pattern: a host name ("example.com", any port),
+// a wildcard ("*.example.com", every sub domain but not example.com itself),
+// or "default" (also "*") for hosts matching nothing else.
+func (v *VHosts) Add(pattern string, h http.Handler) error {
+	pattern = strings.TrimSpace(pattern)
+	switch {
+	case pattern == "default" || pattern == "*":
+		v.def = h
+	case strings.HasPrefix(pattern, "*."):
+		suffix := normalizeHost(pattern[1:])
+		for i, wc := range v.wildcards {
+			if wc.suffix == suffix {
+				v.wildcards[i].handler = h
+				return nil
+			}
+		}
+		v.wildcards = append(v.wildcards, vhostWildcard{suffix: suffix, handler: h})
+		// most specific wildcard first
+		sort.SliceStable(v.wildcards, func(i, j int) bool { return len(v.wildcards[i].suffix) > len(v.wildcards[j].suffix) })
+	case pattern == "" || strings.ContainsAny(pattern, "*/ "):
+		return fmt.Errorf("invalid host pattern %q", pattern)
+	default:
+		v.exact[normalizeHost(pattern)] = h
+	}
+	return nil
+}
+
+// Default returns the handler for unknown hosts, or nil.
+func (v *VHosts) Default() http.Handler {
+	return v.def
+}
+
+// Lookup returns the handler for host: exact match, then the longest wildcard, then the default.
+func (v *VHosts) Lookup(host string) http.Handler {
+	host = normalizeHost(host)
+	if h, ok := v.exact[host]; ok {
+		return h
+	}
+	for _, wc := range v.wildcards {
+		if strings.HasSuffix(host, wc.suffix) {
+			return wc.handler
+		}
+	}
+	return v.def
+}
+
+func (v *VHosts) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	st := time.Now()
+	if h := v.Lookup(req.Host); h != nil {
+		h.ServeHTTP(res, req)
+		return
+	}
+	res.WriteHeader(http.StatusNotFound)
+	slog.Info("accesslog", "method", req.Method, "path", req.URL.Path, "remote", req.RemoteAddr, "status", http.StatusNotFound, "elapsed_ns", time.Since(st), "vhost", "", "host", req.Host)
+}
+
+// VHostConfig is one entry of a virtual host file: the host patterns (see VHosts.Add)
+// and the plugin options for them. rootdir (or rootdirs) is required.
+type VHostConfig struct {
+	Hosts []string `json:"hosts"`
+	Config
+}
+
+type vhostFile struct {
+	VHosts []VHostConfig `json:"vhosts"`
+}
+
+// LoadVHosts builds virtual hosts from dir, where each sub directory serves the host
+// it is named after ("default" for unknown hosts), and from file, a JSON document
+// {"vhosts": [{"hosts": [...], "rootdir": ..., ...}]}. Entries of file win over directories.
+// opts apply to every host before its own options, policy unless the entry sets symlinks.
+// Each access log line carries a "vhost" field.
+func LoadVHosts(file, dir string, policy SymlinkPolicy, opts ...HandlerOption) (*VHosts, error) {
+	vh := NewVHosts()
+	if dir != "" {
+		ents, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, ent := range ents {
+			if !ent.IsDir() || strings.HasPrefix(ent.Name(), ".") {
+				continue
+			}
+			fsys, err := OpenRoot(filepath.Join(dir, ent.Name()), policy)
+			if err != nil {
+				return nil, err
+			}
+			name := strings.ToLower(ent.Name())
+			hdl := NewHandler(fsys, joinOptions(opts, WithAccessLogField("vhost", name))...)
+			if err := vh.Add(name, hdl); err != nil {
+				return nil, err
+			}
+			slog.Info("vhost loaded", "vhost", name, "root", fsys)
+		}
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var vf vhostFile
+		if err := json.Unmarshal(data, &vf); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for i, entry := range vf.VHosts {
+			if len(entry.Hosts) == 0 {
+				return nil, fmt.Errorf("%s: vhost #%d has no hosts", file, i)
+			}
+			hdl, err := entry.handler(policy, opts)
+			if err != nil {
+				return nil, fmt.Errorf("%s: vhost %s: %w", file, entry.Hosts[0], err)
+			}
+			for _, host := range entry.Hosts {
+				if err := vh.Add(host, hdl); err != nil {
+					return nil, fmt.Errorf("%s: %w", file, err)
+				}
+			}
+			slog.Info("vhost loaded", "vhost", entry.Hosts[0], "hosts", entry.Hosts, "root", entry.rootDirs())
+		}
+	}
+	return vh, nil
+}
+
+func (entry *VHostConfig) handler(policy SymlinkPolicy, opts []HandlerOption) (*Handler, error) {
+	roots := entry.rootDirs()
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("rootdir cannot be empty")
+	}
+	if entry.Symlinks != "" {
+		var err error
+		if policy, err = ParseSymlinkPolicy(entry.Symlinks); err != nil {
+			return nil, err
+		}
+	}
+	fsys, err := OpenRoots(roots, policy)
+	if err != nil {
+		return nil, err
+	}
+	own, err := entry.handlerOptions(nil)
+	if err != nil {
+		return nil, err
+	}
+	own = append(own, WithAccessLogField("vhost", entry.Hosts[0]))
+	return NewHandler(fsys, joinOptions(opts, own...)...), nil
+}
+
+// joinOptions returns a new slice of base followed by extra.
+func joinOptions(base []HandlerOption, extra ...HandlerOption) []HandlerOption {
+	res := make([]HandlerOption, 0, len(base)+len(extra))
+	res = append(res, base...)
+	return append(res, extra...)
+}
@@ -0,0 +1,165 @@
+package anystatic
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// vhostTree creates sites/{example.com,default,app}/index.html and a vhosts.json
+func vhostTree(t *testing.T) (string, string) {
+	t.Helper()
+	base := t.TempDir()
+	sites := filepath.Join(base, "sites")
+	for _, host := range []string{"example.com", "default", "app"} {
+		dir := filepath.Join(sites, host)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(host), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(base, "vhosts.json")
+	conf := `{"vhosts": [
+		{"hosts": ["*.example.org", "example.org"], "rootdir": "` + filepath.Join(sites, "app") + `",
+		 "spafallback": "/index.html", "headers": {"X-Site": "app"}},
+		{"hosts": ["example.com"], "rootdir": "` + filepath.Join(sites, "app") + `"}
+	]}`
+	if err := os.WriteFile(file, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file, sites
+}
+
+// TestVHosts_Lookup tests exact, wildcard and default matching
+func TestVHosts_Lookup(t *testing.T) {
+	vh := NewVHosts()
+	for _, pattern := range []string{"example.com", "*.example.com", "*.api.example.com", "default"} {
+		name := pattern
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(name)) })
+		if err := vh.Add(pattern, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testCases := []struct {
+		host   string
+		expect string
+	}{
+		{"example.com", "example.com"},
+		{"EXAMPLE.com:8080", "example.com"},
+		{"example.com.", "example.com"},
+		{"www.example.com", "*.example.com"},
+		{"v1.api.example.com", "*.api.example.com"},
+		{"api.example.com", "*.example.com"},
+		{"other.org", "default"},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		vh.Lookup(tc.host).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Body.String() != tc.expect {
+			t.Errorf("%s: expected %s, got %s", tc.host, tc.expect, w.Body.String())
+		}
+	}
+	for _, bad := range []string{"", "a/b", "ex*.com"} {
+		if err := vh.Add(bad, http.NotFoundHandler()); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+	empty := NewVHosts()
+	if empty.Lookup("example.com") != nil {
+		t.Errorf("expected no handler")
+	}
+}
+
+// TestLoadVHosts tests hosts from a directory and a file with their own options
+func TestLoadVHosts(t *testing.T) {
+	file, sites := vhostTree(t)
+	vh, err := LoadVHosts(file, sites, SymlinkFollow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		host   string
+		path   string
+		status int
+		body   string
+		header string
+	}{
+		{"example.com", "/", http.StatusOK, "app", ""},
+		{"app", "/", http.StatusOK, "app", ""},
+		{"www.example.org", "/some/route", http.StatusOK, "app", "app"},
+		{"example.org:443", "/", http.StatusOK, "app", "app"},
+		{"unknown.net", "/", http.StatusOK, "default", ""},
+		{"unknown.net", "/some/route", http.StatusNotFound, "", ""},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Host = tc.host
+		w := httptest.NewRecorder()
+		vh.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s%s: expected status %d, got %d", tc.host, tc.path, tc.status, w.Code)
+		}
+		if tc.body != "" && w.Body.String() != tc.body {
+			t.Errorf("%s%s: expected body %q, got %q", tc.host, tc.path, tc.body, w.Body.String())
+		}
+		if got := w.Header().Get("X-Site"); got != tc.header {
+			t.Errorf("%s%s: expected X-Site %q, got %q", tc.host, tc.path, tc.header, got)
+		}
+	}
+
+	os.RemoveAll(filepath.Join(sites, "default"))
+	vh, err = LoadVHosts("", sites, SymlinkFollow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "unknown.net"
+	w := httptest.NewRecorder()
+	vh.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d without default, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// TestLoadVHosts_Error tests broken vhost files are reported
+func TestLoadVHosts_Error(t *testing.T) {
+	dir := t.TempDir()
+	for name, conf := range map[string]string{
+		"syntax.json":  `{"vhosts": [`,
+		"nohosts.json": `{"vhosts": [{"rootdir": "/tmp"}]}`,
+		"noroot.json":  `{"vhosts": [{"hosts": ["a.example"]}]}`,
+		"badhost.json": `{"vhosts": [{"hosts": ["a/b"], "rootdir": "/tmp"}]}`,
+	} {
+		file := filepath.Join(dir, name)
+		os.WriteFile(file, []byte(conf), 0o644)
+		if _, err := LoadVHosts(file, "", SymlinkFollow); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// TestNew_VHosts tests the plugin with a vhost directory and rootdir as default
+func TestNew_VHosts(t *testing.T) {
+	_, sites := vhostTree(t)
+	os.RemoveAll(filepath.Join(sites, "default"))
+	config := CreateConfig()
+	config.VHostsDir = sites
+	config.RootDir = filepath.Join(sites, "app")
+	hdl, err := New(context.Background(), http.NotFoundHandler(), config, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "unknown.net"
+	w := httptest.NewRecorder()
+	hdl.ServeHTTP(w, req)
+	if w.Body.String() != "app" {
+		t.Errorf("expected default from rootdir, got %q", w.Body.String())
+	}
+}